@@ -0,0 +1,94 @@
+package artifacts
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Artifact is a file under a build's 'archive' directory.
+// Path is relative to the 'archive' directory
+type Artifact struct {
+	Path   string
+	Size   int64
+	Md5    string
+	Sha256 string
+}
+
+// List walks 'archiveDir' and returns its files sorted by path.
+// Checksums are calculated only if 'checksum' is true because it reads every file
+func List(archiveDir string, checksum bool) ([]Artifact, error) {
+	list := []Artifact{}
+	err := filepath.Walk(archiveDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(archiveDir, path)
+		if err != nil {
+			return err
+		}
+		a := Artifact{Path: filepath.ToSlash(rel), Size: info.Size()}
+		if checksum {
+			a.Md5, a.Sha256, err = Checksum(path)
+			if err != nil {
+				return err
+			}
+		}
+		list = append(list, a)
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list, err
+}
+
+// Checksum returns MD5 and SHA-256 digests of a file in one read.
+// MD5 is what Jenkins uses for its fingerprints
+func Checksum(path string) (md5sum, sha256sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	h1 := md5.New()
+	h2 := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h2), f); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h1.Sum(nil)), hex.EncodeToString(h2.Sum(nil)), nil
+}
+
+func TotalSize(list []Artifact) int64 {
+	var total int64
+	for _, a := range list {
+		total += a.Size
+	}
+	return total
+}
+
+// Missing returns patterns that no artifact matches.
+// A pattern is matched against both a relative path and a base name of an artifact
+func Missing(list []Artifact, patterns []string) []string {
+	missing := []string{}
+	for _, pattern := range patterns {
+		found := false
+		for _, a := range list {
+			m1, _ := filepath.Match(pattern, a.Path)
+			m2, _ := filepath.Match(pattern, filepath.Base(a.Path))
+			if m1 || m2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, pattern)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,36 @@
+package artifacts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func ExampleList() {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "BUILD-ARTIFACTS", "k7hp"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "BUILD-ARTIFACTS", "k7hp", "starfish-image-k7hp.tar.gz"), []byte("rootfs"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "build.log"), []byte("log"), 0644)
+
+	list, err := List(dir, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, a := range list {
+		fmt.Println(a.Path, a.Size, a.Md5)
+	}
+	fmt.Println(TotalSize(list))
+	fmt.Println(Missing(list, []string{"*.tar.gz", "*.ipk"}))
+	// Output:
+	// BUILD-ARTIFACTS/k7hp/starfish-image-k7hp.tar.gz 6 307cfa551ed600e2db40b7885ce3ceda
+	// build.log 3 dc1d71bbb5c4d2a5e936db79ef10c19f
+	// 9
+	// [*.ipk]
+}
@@ -29,4 +29,132 @@ type BuildData struct {
 	Time_rsync_artifacts    float64
 	Cause                   bson.M
 	Parameters              bson.M
+	Tests_total             int
+	Tests_passed            int
+	Tests_failed            int
+	Tests_skipped           int
+	Tests_duration          float64
+	Artifacts               []ArtifactData
+	Artifacts_count         int
+	Artifacts_size          int64
+	Missing_artifacts       []string
+	Manifests               []ManifestData
+	Environment             bson.M
+	// Provisional is true if a build was running when it was stored
+	Provisional bool
+	// Source_mtime and Source_hash are a modification time and a SHA-256 checksum of build.xml
+	Source_mtime int64
+	Source_hash  string
+	// Parser_version is a version of a parser that made this record
+	Parser_version int
+	// Source_deleted is true if a build directory has been deleted after stored
+	Source_deleted bool
+	Layers         []LayerData
+	// Failures are error lines of bitbake on a build log
+	Failures []string
+	// Sstate_hit_rate is a percentage of sstate objects found among wanted ones
+	Sstate_wanted   int
+	Sstate_found    int
+	Sstate_hit_rate float64
+	// Instance is a name of a Jenkins instance that ran a build.
+	// A build is unique by an instance, a job name and a build number
+	Instance string
+}
+
+// LayerData is a layer and its branch and revision on a build configuration of bitbake
+type LayerData struct {
+	Name     string
+	Branch   string
+	Revision string
+}
+
+// ArtifactData is a file archived with a build under 'archive/'
+type ArtifactData struct {
+	Path   string
+	Size   int64
+	Md5    string
+	Sha256 string
+}
+
+// TestCaseData is a result of a test case that a build publishes with 'junitResult.xml'.
+// It's stored on a separate collection to track each case over builds
+type TestCaseData struct {
+	ID            bson.ObjectId `bson:"_id,omitempty"`
+	Jobname       string
+	Buildnumber   int
+	Start         int
+	Suite         string
+	Classname     string
+	Name          string
+	Duration      float64
+	Status        string
+	Failed_since  int
+	Error_message string
+	Instance      string
+}
+
+// ManifestData is an image or license manifest that is archived with a build
+type ManifestData struct {
+	Path     string
+	Type     string
+	Packages []PackageData
+}
+
+type PackageData struct {
+	Name    string
+	Arch    string
+	Version string
+	Recipe  string
+	License string
+}
+
+// FingerprintData is a Jenkins fingerprint record of a file.
+// 'Original' is a build that produced the file and 'Usages' are builds that used it
+type FingerprintData struct {
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	Md5       string
+	Filename  string
+	Timestamp int
+	Original  FingerprintBuild
+	Usages    []FingerprintUsage
+}
+
+type FingerprintBuild struct {
+	Jobname     string
+	Buildnumber int
+}
+
+type FingerprintUsage struct {
+	Jobname string
+	Ranges  []FingerprintRange
+}
+
+// FingerprintRange is a range of build numbers, both ends are inclusive
+type FingerprintRange struct {
+	Start int
+	End   int
+}
+
+// JobData is a state of a job that Jenkins keeps out of build directories.
+// Permalinks have build numbers by permalink names like 'lastSuccessfulBuild'
+type JobData struct {
+	ID               bson.ObjectId `bson:"_id,omitempty"`
+	Jobname          string
+	Machine          string
+	Next_buildnumber int
+	Permalinks       bson.M
+	Updated          int
+}
+
+// IngestErrorData is a build that couldn't be ingested. Stage is where it failed,
+// 'read', 'parse' or 'store', and Time is when it failed
+type IngestErrorData struct {
+	ID          bson.ObjectId `bson:"_id,omitempty"`
+	Instance    string
+	Jobname     string
+	Buildnumber int
+	Path        string
+	Stage       string
+	Message     string
+	Time        int
 }
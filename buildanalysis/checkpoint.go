@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Checkpoint keeps builds that have been written on a file, so a run that has been interrupted
+// can be resumed by a next run with the same file, without analyzing them again.
+// Builds are added once they are stored, and saved only after a sink flushes them,
+// so a build that is on the file has been written. It's safe for concurrent use.
+//
+// A file is a journal of JSON lines. The first lines have an instance and builds
+// that were stored when a run started, so a resumed run doesn't have to read them again,
+// and every Save appends a line of builds of each job that have been flushed since
+type Checkpoint struct {
+	Path     string
+	Instance string
+
+	mutex sync.Mutex
+	// resumed is true once a file of the instance has been loaded or started
+	resumed bool
+	stored  map[string][]checkpointBuild
+	done    map[string]map[int]bool
+	pending map[string][]int
+}
+
+// checkpointLine is a line of a checkpoint file. The first line has an instance,
+// and others have builds of a job that were stored when a run started, or build numbers that have been written
+type checkpointLine struct {
+	Instance string             `json:",omitempty"`
+	Job      string             `json:",omitempty"`
+	Stored   *[]checkpointBuild `json:",omitempty"`
+	Done     []int              `json:",omitempty"`
+}
+
+// checkpointBuild has fields of a stored build to find builds to analyze
+type checkpointBuild struct {
+	N           int
+	Result      string `json:",omitempty"`
+	Provisional bool   `json:",omitempty"`
+	Mtime       int64  `json:",omitempty"`
+	Hash        string `json:",omitempty"`
+}
+
+// LoadCheckpoint reads a checkpoint file. A missing file is an empty checkpoint,
+// and builds of another instance aren't loaded. A line that was cut by a crash while it was written
+// isn't a JSON and is ignored, so its builds are analyzed again
+func LoadCheckpoint(path string, instance string) (*Checkpoint, error) {
+	c := &Checkpoint{
+		Path:     path,
+		Instance: instance,
+		stored:   make(map[string][]checkpointBuild),
+		done:     make(map[string]map[int]bool),
+		pending:  make(map[string][]int),
+	}
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := []checkpointLine{}
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
+	scanner.Buffer(nil, len(dat)+1)
+	for scanner.Scan() {
+		line := checkpointLine{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[0].Instance != instance {
+		return c, nil
+	}
+	c.resumed = true
+	for _, line := range lines[1:] {
+		if line.Stored != nil {
+			c.stored[line.Job] = *line.Stored
+		}
+		if c.done[line.Job] == nil {
+			c.done[line.Job] = make(map[int]bool)
+		}
+		for _, n := range line.Done {
+			c.done[line.Job][n] = true
+		}
+	}
+	return c, nil
+}
+
+// Len returns a number of builds that have been saved
+func (c *Checkpoint) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, buildNumbers := range c.done {
+		n += len(buildNumbers)
+	}
+	return n
+}
+
+// Stored returns builds of a job that were stored when an interrupted run started,
+// with results and sources, and false if a checkpoint doesn't resume a run of the job
+func (c *Checkpoint) Stored(jobName string) ([]builddata.BuildData, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	builds, ok := c.stored[jobName]
+	stored := make([]builddata.BuildData, 0, len(builds))
+	for _, b := range builds {
+		stored = append(stored, builddata.BuildData{
+			Jobname:      jobName,
+			Buildnumber:  b.N,
+			Result:       b.Result,
+			Provisional:  b.Provisional,
+			Source_mtime: b.Mtime,
+			Source_hash:  b.Hash,
+		})
+	}
+	return stored, c.resumed && ok
+}
+
+// SetStored keeps builds of a job that are stored when a run starts.
+// They are written on a new file by a first Save, and a file that resumes a run keeps its own ones
+func (c *Checkpoint) SetStored(jobName string, stored []builddata.BuildData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.resumed {
+		return
+	}
+	builds := make([]checkpointBuild, 0, len(stored))
+	for _, v := range stored {
+		builds = append(builds, checkpointBuild{
+			N:           v.Buildnumber,
+			Result:      v.Result,
+			Provisional: v.Provisional,
+			Mtime:       v.Source_mtime,
+			Hash:        v.Source_hash,
+		})
+	}
+	c.stored[jobName] = builds
+}
+
+// Done checks if a build directory has been written by a previous run
+func (c *Checkpoint) Done(buildDir string) bool {
+	jobName, buildNumber := buildDirNames(buildDir)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.done[jobName][buildNumber]
+}
+
+// Add adds a build directory that has been stored, and is saved on a next Save
+func (c *Checkpoint) Add(buildDir string) {
+	jobName, buildNumber := buildDirNames(buildDir)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending[jobName] = append(c.pending[jobName], buildNumber)
+}
+
+// Save appends builds that have been added since a last Save, and syncs a file.
+// It must be called after a sink flushes builds. A first Save of a run that isn't resumed
+// starts a new file with an instance and stored builds
+func (c *Checkpoint) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if !c.resumed {
+		flag = os.O_WRONLY | os.O_TRUNC | os.O_CREATE
+		enc.Encode(checkpointLine{Instance: c.Instance})
+		jobNames := []string{}
+		for jobName := range c.stored {
+			jobNames = append(jobNames, jobName)
+		}
+		sort.Strings(jobNames)
+		for _, jobName := range jobNames {
+			builds := c.stored[jobName]
+			enc.Encode(checkpointLine{Job: jobName, Stored: &builds})
+		}
+	}
+	jobNames := []string{}
+	for jobName := range c.pending {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+	for _, jobName := range jobNames {
+		buildNumbers := c.pending[jobName]
+		sort.Ints(buildNumbers)
+		enc.Encode(checkpointLine{Job: jobName, Done: buildNumbers})
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(c.Path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	c.resumed = true
+	for jobName, buildNumbers := range c.pending {
+		if c.done[jobName] == nil {
+			c.done[jobName] = make(map[int]bool)
+		}
+		for _, n := range buildNumbers {
+			c.done[jobName][n] = true
+		}
+	}
+	c.pending = make(map[string][]int)
+	return nil
+}
+
+// Remove removes a checkpoint file once a run has completed, so a next run starts over
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func ExampleCheckpoint() {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+	jobDir := "/jenkins/jobs/starfish-drd4tv-official-h15"
+
+	// A first run keeps builds that were stored when it started, and appends builds as they are flushed
+	c, _ := LoadCheckpoint(path, "lge")
+	_, resumed := c.Stored("starfish-drd4tv-official-h15")
+	fmt.Println(resumed)
+	c.SetStored("starfish-drd4tv-official-h15", []builddata.BuildData{
+		{Buildnumber: 1, Result: "SUCCESS", Source_mtime: 1551657600, Source_hash: "e3b0c442"},
+		{Buildnumber: 2, Provisional: true},
+	})
+	c.Add(jobDir + "/builds/4")
+	c.Add(jobDir + "/builds/3")
+	fmt.Println(c.Done(jobDir+"/builds/3"), c.Save(), c.Done(jobDir+"/builds/3"))
+	c.Add(jobDir + "/builds/5")
+	c.Save()
+	dat, _ := ioutil.ReadFile(path)
+	fmt.Print(string(dat))
+
+	// A line that was cut by a crash is ignored
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"Job":"starfish-drd4tv-official-h15","Done":[6,7`)
+	f.Close()
+
+	// A next run resumes without reading stored builds again
+	c, err = LoadCheckpoint(path, "lge")
+	fmt.Println(err, c.Len())
+	stored, resumed := c.Stored("starfish-drd4tv-official-h15")
+	for _, v := range stored {
+		fmt.Printf("%s %d %q %v %d %q\n", v.Jobname, v.Buildnumber, v.Result, v.Provisional, v.Source_mtime, v.Source_hash)
+	}
+	fmt.Println(resumed)
+	fmt.Println(c.Done(jobDir+"/builds/5"), c.Done(jobDir+"/builds/6"))
+
+	// A checkpoint of another instance is ignored
+	c, _ = LoadCheckpoint(path, "")
+	fmt.Println(c.Len())
+	// Output:
+	// false
+	// false <nil> true
+	// {"Instance":"lge"}
+	// {"Job":"starfish-drd4tv-official-h15","Stored":[{"N":1,"Result":"SUCCESS","Mtime":1551657600,"Hash":"e3b0c442"},{"N":2,"Provisional":true}]}
+	// {"Job":"starfish-drd4tv-official-h15","Done":[3,4]}
+	// {"Job":"starfish-drd4tv-official-h15","Done":[5]}
+	// <nil> 3
+	// starfish-drd4tv-official-h15 1 "SUCCESS" false 1551657600 "e3b0c442"
+	// starfish-drd4tv-official-h15 2 "" true 0 ""
+	// true
+	// true false
+	// 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/all4dich/golang/buildanalysis/oebuildjobs"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildSelection narrows down builds of a job to analyze.
+// Zero values mean no limit
+type BuildSelection struct {
+	From        int
+	To          int
+	Last        int
+	Since       time.Time
+	NewestFirst bool
+}
+
+// ParseSince parses a date like '2019-03-04' or a time in RFC 3339
+func ParseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("Invalid date: " + s)
+}
+
+// buildStartTime returns a start time of a build from its build.xml
+func buildStartTime(buildDir string) (time.Time, error) {
+	dat, err := ioutil.ReadFile(buildDir + "/build.xml")
+	if err != nil {
+		return time.Time{}, err
+	}
+	dat = []byte(strings.Replace(string(dat), "<?xml version='1.1'", "<?xml version='1.0'", 1))
+	v := oebuildjobs.BuildInfo{}
+	if err := xml.Unmarshal(dat, &v); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(v.Start/1000), 0), nil
+}
+
+// SelectBuilds returns build directories in 'builds' sorted by build numbers, not by names,
+// and filtered by 'sel'. 'Since' is checked from the newest build and stops at the first
+// build that started before it, because build numbers increase with start times
+func SelectBuilds(buildsDir string, builds []os.FileInfo, sel BuildSelection) []os.FileInfo {
+	numbers := make(map[string]int)
+	selected := make([]os.FileInfo, 0, len(builds))
+	for _, build := range builds {
+		if !build.IsDir() || !validBuildNumber.MatchString(build.Name()) {
+			continue
+		}
+		n, err := strconv.Atoi(build.Name())
+		if err != nil {
+			continue
+		}
+		if (sel.From != 0 && n < sel.From) || (sel.To != 0 && n > sel.To) {
+			continue
+		}
+		numbers[build.Name()] = n
+		selected = append(selected, build)
+	}
+	// Newest first
+	sort.Slice(selected, func(i, j int) bool {
+		return numbers[selected[i].Name()] > numbers[selected[j].Name()]
+	})
+	if sel.Last != 0 && len(selected) > sel.Last {
+		selected = selected[:sel.Last]
+	}
+	if !sel.Since.IsZero() {
+		for i, build := range selected {
+			start, err := buildStartTime(buildsDir + "/" + build.Name())
+			if err == nil && start.Before(sel.Since) {
+				selected = selected[:i]
+				break
+			}
+		}
+	}
+	if !sel.NewestFirst {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+	return selected
+}
+
+// ReadBuilds reads a builds directory of a job and selects builds by 'sel'
+func ReadBuilds(buildsDir string, sel BuildSelection) ([]os.FileInfo, error) {
+	builds, err := ioutil.ReadDir(buildsDir)
+	if err != nil {
+		return nil, err
+	}
+	return SelectBuilds(buildsDir, builds, sel), nil
+}
+
+// HasBuildFiles checks if a build directory has both build.xml and log
+func HasBuildFiles(buildDir string) bool {
+	_, err1 := os.Stat(buildDir + "/build.xml")
+	_, err2 := os.Stat(buildDir + "/log")
+	return err1 == nil && err2 == nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// fakeBuild is an entry of a builds directory
+type fakeBuild struct {
+	name  string
+	isDir bool
+}
+
+func (f fakeBuild) Name() string       { return f.name }
+func (f fakeBuild) Size() int64        { return 0 }
+func (f fakeBuild) Mode() os.FileMode  { return 0755 }
+func (f fakeBuild) ModTime() time.Time { return time.Time{} }
+func (f fakeBuild) IsDir() bool        { return f.isDir }
+func (f fakeBuild) Sys() interface{}   { return nil }
+
+func selectedNames(builds []os.FileInfo) []string {
+	names := []string{}
+	for _, build := range builds {
+		names = append(names, build.Name())
+	}
+	return names
+}
+
+func ExampleSelectBuilds() {
+	// Entries are sorted by names like ioutil.ReadDir does
+	builds := []os.FileInfo{
+		fakeBuild{"10", true},
+		fakeBuild{"10000", true},
+		fakeBuild{"11", true},
+		fakeBuild{"9", true},
+		fakeBuild{"lastStableBuild", true},
+		fakeBuild{"legacyIds", false},
+		fakeBuild{"permalinks", false},
+	}
+	// Build numbers are compared as numbers, so '9' comes before '10000'
+	fmt.Println(selectedNames(SelectBuilds("", builds, BuildSelection{})))
+	fmt.Println(selectedNames(SelectBuilds("", builds, BuildSelection{From: 10, To: 11})))
+	fmt.Println(selectedNames(SelectBuilds("", builds, BuildSelection{Last: 2})))
+	fmt.Println(selectedNames(SelectBuilds("", builds, BuildSelection{Last: 2, NewestFirst: true})))
+	fmt.Println(selectedNames(SelectBuilds("", builds, BuildSelection{From: 10, Last: 10, NewestFirst: true})))
+	// Output:
+	// [9 10 11 10000]
+	// [10 11]
+	// [11 10000]
+	// [10000 11]
+	// [10000 11 10]
+}
+
+func ExampleSelectBuilds_since() {
+	buildsDir, err := ioutil.TempDir("", "builds")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(buildsDir)
+	since, _ := ParseSince("2019-03-04")
+	builds := []os.FileInfo{}
+	for _, n := range []int{8, 9, 10, 11} {
+		// A build a day from two days before 'since'
+		start := since.Add(time.Duration(n-10)*24*time.Hour).Unix() * 1000
+		os.MkdirAll(buildsDir+"/"+strconv.Itoa(n), 0755)
+		ioutil.WriteFile(buildsDir+"/"+strconv.Itoa(n)+"/build.xml",
+			[]byte("<?xml version='1.1' encoding='UTF-8'?>\n<build><startTime>"+strconv.FormatInt(start, 10)+"</startTime></build>\n"), 0644)
+		builds = append(builds, fakeBuild{strconv.Itoa(n), true})
+	}
+	fmt.Println(selectedNames(SelectBuilds(buildsDir, builds, BuildSelection{Since: since})))
+	fmt.Println(selectedNames(SelectBuilds(buildsDir, builds, BuildSelection{Since: since, NewestFirst: true})))
+	fmt.Println(selectedNames(SelectBuilds(buildsDir, builds, BuildSelection{Since: since, Last: 1})))
+	_, err = ParseSince("03/04/2019")
+	fmt.Println(err)
+	// Output:
+	// [10 11]
+	// [11 10]
+	// [11]
+	// Invalid date: 03/04/2019
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"sync"
+)
+
+// BuildDataJSON returns a record as a JSON object with the same field names
+// that it has on a database. Keys are sorted, so outputs can be compared with diff
+func BuildDataJSON(data builddata.BuildData) ([]byte, error) {
+	raw, err := bson.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// jsonLinesSink writes records as JSON Lines in the order of build directories.
+// A record is held until previous builds are written or have failed, so it's also
+// an ErrorRecorder that is told of failed builds
+type jsonLinesSink struct {
+	w     io.Writer
+	order map[string]int
+
+	mutex sync.Mutex
+	// lines: records that are held by indexes of build directories. A failed build has nil
+	lines map[int][]byte
+	next  int
+}
+
+func newJSONLinesSink(w io.Writer, buildDirs []string) *jsonLinesSink {
+	s := &jsonLinesSink{w: w, order: make(map[string]int), lines: make(map[int][]byte)}
+	for i, buildDir := range buildDirs {
+		s.order[buildDir] = i
+	}
+	return s
+}
+
+// done holds a line of a build directory, and writes lines that are next in order
+func (s *jsonLinesSink) done(buildDir string, line []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, ok := s.order[buildDir]
+	if !ok {
+		return
+	}
+	s.lines[i] = line
+	for line, ok := s.lines[s.next]; ok; line, ok = s.lines[s.next] {
+		if line != nil {
+			s.w.Write(append(line, '\n'))
+		}
+		delete(s.lines, s.next)
+		s.next++
+	}
+}
+
+func (s *jsonLinesSink) Open() error {
+	return nil
+}
+
+func (s *jsonLinesSink) UpsertBuild(r sink.Record) error {
+	line, err := BuildDataJSON(r.Build)
+	if err != nil {
+		return err
+	}
+	s.done(r.Path, line)
+	return nil
+}
+
+func (s *jsonLinesSink) Flush() error {
+	return nil
+}
+
+func (s *jsonLinesSink) Close() error {
+	return nil
+}
+
+func (s *jsonLinesSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	return []builddata.BuildData{}, nil
+}
+
+func (s *jsonLinesSink) RecordError(e builddata.IngestErrorData) error {
+	s.done(e.Path, nil)
+	return nil
+}
+
+func (s *jsonLinesSink) ClearErrors(paths []string) error {
+	return nil
+}
+
+// DryRun analyzes builds with a pipeline 'p' and writes their records on 'w'
+// as JSON Lines in the order of 'buildDirs'. Builds that can't be analyzed are added to 'p.Failures'
+func DryRun(w io.Writer, buildDirs []string, p *Pipeline) error {
+	s := newJSONLinesSink(w, buildDirs)
+	p.Store = s
+	if p.Failures == nil {
+		p.Failures = &IngestErrors{}
+	}
+	p.Failures.Recorder = s
+	builds := make(chan string)
+	go func() {
+		for _, buildDir := range buildDirs {
+			builds <- buildDir
+		}
+		close(builds)
+	}()
+	return p.Run(context.Background(), builds)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+func ExampleDryRun() {
+	var buf bytes.Buffer
+	failures := &IngestErrors{}
+	err := DryRun(&buf, []string{
+		samplesJobsDir + "/tools/builds/9",
+		samplesJobsDir + "/starfish-drd4tv-official-h15/builds/2",
+		samplesJobsDir + "/tools/builds/1",
+	}, &Pipeline{Failures: failures, Parsers: 2, Transformers: 2})
+	fmt.Println(err, failures.Len())
+	// Keys are sorted, and lines are in the order of build directories without failed builds.
+	// A modification time of build.xml depends on a checkout
+	fmt.Print(regexp.MustCompile(`"source_mtime":\d+`).ReplaceAllString(buf.String(), `"source_mtime":0`))
+	// Output:
+	// <nil> 1
+	// {"artifacts":[],"artifacts_count":0,"artifacts_size":0,"buildnumber":2,"cause":{"parent_buildnumber":0,"parent_project":"","parent_url":"","parent_user":"","retriggeredby":"","userid":"builder"},"description":"","duration":1200,"duration_in_queue":0,"environment":{},"failures":["ERROR: Task do_compile failed"],"gerritchangeinfo":{"branch":"","changeid":"","changenumber":0,"patchset":{"author":{"email":"","name":""},"createdon":"","number":0,"parents":"","ref":"","uploader":{"email":"","name":""}},"project":"","receivedon":0,"url":""},"gitchangeinfo":{"branch":"","buildnumber":0,"commithash":"","repositoryurl":""},"host":"node1","instance":"","jobname":"starfish-drd4tv-official-h15","layers":[{"branch":"master","name":"meta-starfish-product","revision":"0123abcd"}],"machine":"h15","manifests":[],"missing_artifacts":[],"parameters":{"BB_VERSION":"1.40.0","BRANCH":"master","BUILD_SYS":"","DISTRO":"starfish","DISTRO_VERSION":"","NATIVELSBSTRING":"","TARGET_FPU":"","TARGET_SYS":"","TUNE_FEATURES":"","WEBOS_DISTRO_BUILD_ID":"","WEBOS_DISTRO_MANUFACTURING_VERSION":"","WEBOS_DISTRO_RELEASE_CODENAME":"","WEBOS_DISTRO_TOPDIR_DESCRIBE":"","WEBOS_DISTRO_TOPDIR_REVISION":"","WEBOS_ENCRYPTION_KEY_TYPE":"","caprica":""},"parser_version":4,"provisional":false,"result":"FAILURE","source_deleted":false,"source_hash":"f847133a0d914ff75e17454807dd3a8228c4b92a1f5d087408e1c8b01c5cede4","source_mtime":0,"sstate_found":80,"sstate_hit_rate":80,"sstate_wanted":100,"start":1600003600,"tests_duration":0,"tests_failed":0,"tests_passed":0,"tests_skipped":0,"tests_total":0,"time_bitbake":0,"time_build_sh":0,"time_rm_build":0,"time_rm_build_artifacts":0,"time_rm_downloads":0,"time_rm_sstate":0,"time_rsync_artifacts":0,"timediff":0,"waiting":0,"workspace":"/home/jenkins/workspace/starfish-drd4tv-official-h15"}
+	// {"artifacts":[],"artifacts_count":0,"artifacts_size":0,"buildnumber":1,"cause":{"parent_buildnumber":0,"parent_project":"","parent_url":"","parent_user":"","retriggeredby":"","userid":"builder"},"description":"","duration":60,"duration_in_queue":0,"environment":{},"failures":[],"gerritchangeinfo":{"branch":"","changeid":"","changenumber":0,"patchset":{"author":{"email":"","name":""},"createdon":"","number":0,"parents":"","ref":"","uploader":{"email":"","name":""}},"project":"","receivedon":0,"url":""},"gitchangeinfo":{"branch":"","buildnumber":0,"commithash":"","repositoryurl":""},"host":"node1","instance":"","jobname":"tools","layers":[{"branch":"master","name":"meta-starfish-product","revision":"0123abcd"}],"machine":"","manifests":[],"missing_artifacts":[],"parameters":{"BB_VERSION":"1.40.0","BRANCH":"master","BUILD_SYS":"","DISTRO":"starfish","DISTRO_VERSION":"","NATIVELSBSTRING":"","TARGET_FPU":"","TARGET_SYS":"","TUNE_FEATURES":"","WEBOS_DISTRO_BUILD_ID":"","WEBOS_DISTRO_MANUFACTURING_VERSION":"","WEBOS_DISTRO_RELEASE_CODENAME":"","WEBOS_DISTRO_TOPDIR_DESCRIBE":"","WEBOS_DISTRO_TOPDIR_REVISION":"","WEBOS_ENCRYPTION_KEY_TYPE":"","caprica":""},"parser_version":4,"provisional":false,"result":"SUCCESS","source_deleted":false,"source_hash":"a8cf4982135fc5b3fe4c1cb157935b28481b9e595b99642b1d4b5cd1f789d4cb","source_mtime":0,"sstate_found":80,"sstate_hit_rate":80,"sstate_wanted":100,"start":1600007200,"tests_duration":0,"tests_failed":0,"tests_passed":0,"tests_skipped":0,"tests_total":0,"time_bitbake":0,"time_build_sh":0,"time_rm_build":0,"time_rm_build_artifacts":0,"time_rm_downloads":0,"time_rm_sstate":0,"time_rsync_artifacts":0,"timediff":0,"waiting":0,"workspace":"/home/jenkins/workspace/tools"}
+}
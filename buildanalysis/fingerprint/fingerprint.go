@@ -0,0 +1,106 @@
+package fingerprint
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const timestampLayout = "2006-01-02 15:04:05.999 MST"
+
+type Build struct {
+	Name   string `xml:"name"`
+	Number int    `xml:"number"`
+}
+
+// Range is a range of build numbers, both ends are inclusive
+type Range struct {
+	Start int
+	End   int
+}
+
+type Usage struct {
+	Name   string `xml:"string"`
+	Ranges string `xml:"ranges"`
+}
+
+// Fingerprint is a record on '$JENKINS_HOME/fingerprints/xx/yy/*.xml'.
+// 'Original' is a build that produced a file and 'Usages' are
+// builds of each job that used it
+type Fingerprint struct {
+	XMLName   xml.Name `xml:"fingerprint"`
+	Timestamp string   `xml:"timestamp"`
+	Original  *Build   `xml:"original"`
+	Md5sum    string   `xml:"md5sum"`
+	FileName  string   `xml:"fileName"`
+	Usages    []Usage  `xml:"usages>entry"`
+}
+
+func Parse(dat []byte) (Fingerprint, error) {
+	v := Fingerprint{}
+	dat = []byte(strings.Replace(string(dat), "<?xml version='1.1'", "<?xml version='1.0'", 1))
+	err := xml.Unmarshal(dat, &v)
+	return v, err
+}
+
+func ParseFile(path string) (Fingerprint, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	return Parse(dat)
+}
+
+// Time returns a timestamp of a fingerprint as unix time in seconds
+func (v Fingerprint) Time() int {
+	t, err := time.Parse(timestampLayout, v.Timestamp)
+	if err != nil {
+		return 0
+	}
+	return int(t.Unix())
+}
+
+// ParseRanges parses a range set that Jenkins writes like '1-3,5,10-12'
+func ParseRanges(s string) ([]Range, error) {
+	ranges := []Range{}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		ends := strings.SplitN(r, "-", 2)
+		start, err := strconv.Atoi(ends[0])
+		if err != nil {
+			return ranges, errors.New("Invalid range: " + r)
+		}
+		end := start
+		if len(ends) == 2 {
+			end, err = strconv.Atoi(ends[1])
+			if err != nil {
+				return ranges, errors.New("Invalid range: " + r)
+			}
+		}
+		ranges = append(ranges, Range{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+// Walk calls 'f' for each fingerprint record under 'fingerprintsDir'
+func Walk(fingerprintsDir string, f func(path string, v Fingerprint, err error)) error {
+	return filepath.Walk(fingerprintsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || filepath.Ext(path) != ".xml" {
+			return nil
+		}
+		v, err := ParseFile(path)
+		f(path, v, err)
+		return nil
+	})
+}
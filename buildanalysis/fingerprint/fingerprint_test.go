@@ -0,0 +1,44 @@
+package fingerprint
+
+import (
+	"fmt"
+)
+
+const sampleFingerprint = `<?xml version='1.1' encoding='UTF-8'?>
+<fingerprint>
+  <timestamp>2019-03-04 05:06:07.89 UTC</timestamp>
+  <original>
+    <name>starfish-drd4tv-official-h15</name>
+    <number>297</number>
+  </original>
+  <md5sum>307cfa551ed600e2db40b7885ce3ceda</md5sum>
+  <fileName>BUILD-ARTIFACTS/starfish-image-h15.tar.gz</fileName>
+  <usages>
+    <entry>
+      <string>starfish-drd4tv-official-h15</string>
+      <ranges>297</ranges>
+    </entry>
+    <entry>
+      <string>starfish-drd4tv-test-h15</string>
+      <ranges>1001-1003,1007</ranges>
+    </entry>
+  </usages>
+  <facets/>
+</fingerprint>`
+
+func ExampleParse() {
+	v, err := Parse([]byte(sampleFingerprint))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(v.Original.Name, v.Original.Number, v.Md5sum, v.Time())
+	for _, u := range v.Usages {
+		ranges, _ := ParseRanges(u.Ranges)
+		fmt.Println(u.Name, ranges)
+	}
+	// Output:
+	// starfish-drd4tv-official-h15 297 307cfa551ed600e2db40b7885ce3ceda 1551675967
+	// starfish-drd4tv-official-h15 [{297 297}]
+	// starfish-drd4tv-test-h15 [{1001 1003} {1007 1007}]
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/fingerprint"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"log"
+)
+
+func NewFingerprintData(v fingerprint.Fingerprint) builddata.FingerprintData {
+	data := builddata.FingerprintData{
+		Md5:       v.Md5sum,
+		Filename:  v.FileName,
+		Timestamp: v.Time(),
+		Usages:    []builddata.FingerprintUsage{},
+	}
+	// A file that Jenkins didn't produce, e.g. a file copied from outside, doesn't have 'original'
+	if v.Original != nil {
+		data.Original = builddata.FingerprintBuild{
+			Jobname:     v.Original.Name,
+			Buildnumber: v.Original.Number,
+		}
+	}
+	for _, u := range v.Usages {
+		ranges, err := fingerprint.ParseRanges(u.Ranges)
+		if err != nil {
+			log.Println("ERROR: " + v.Md5sum + ": " + err.Error())
+		}
+		usage := builddata.FingerprintUsage{Jobname: u.Name, Ranges: []builddata.FingerprintRange{}}
+		for _, r := range ranges {
+			usage.Ranges = append(usage.Ranges, builddata.FingerprintRange{Start: r.Start, End: r.End})
+		}
+		data.Usages = append(data.Usages, usage)
+	}
+	return data
+}
+
+// IngestFingerprints parses every record on '$JENKINS_HOME/fingerprints'
+// and upserts them into a store keyed by an MD5 checksum
+func IngestFingerprints(fingerprintsDir string, store sink.Store) error {
+	n := 0
+	err := fingerprint.Walk(fingerprintsDir, func(path string, v fingerprint.Fingerprint, err error) {
+		if err != nil {
+			log.Println("ERROR: Cannot parse " + path + ": " + err.Error())
+			return
+		}
+		data := NewFingerprintData(v)
+		if err := store.UpsertFingerprint(data); err != nil {
+			log.Println("ERROR: Cannot store " + path + ": " + err.Error())
+			return
+		}
+		n++
+	})
+	log.Println("INFO: Fingerprints = ", n)
+	return err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stages of ingesting a build where it can fail
+const (
+	// StageRead: build.xml can't be opened or read
+	StageRead = "read"
+	// StageParse: build.xml isn't a valid XML of a build
+	StageParse = "parse"
+	// StageStore: a record can't be written on a sink
+	StageStore = "store"
+)
+
+// BuildError is an error of a build directory at a stage of ingesting it
+type BuildError struct {
+	Path  string
+	Stage string
+	Err   error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Stage, e.Path, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// buildDirNames returns a job name and a build number of a directory like '.../jobs/<job>/builds/<number>'
+func buildDirNames(buildDir string) (jobName string, buildNumber int) {
+	buildNumber, _ = strconv.Atoi(filepath.Base(buildDir))
+	return filepath.Base(filepath.Dir(filepath.Dir(buildDir))), buildNumber
+}
+
+// IngestErrors collects builds that failed on a run, so the run goes on and reports them at the end.
+// Errors are also stored with 'Recorder' if it's set. It's safe for concurrent use
+type IngestErrors struct {
+	Recorder sink.ErrorRecorder
+
+	mutex  sync.Mutex
+	errors []*BuildError
+}
+
+// Add keeps an error of a build, and replaces an error that the build had before on a run.
+// An error that isn't a BuildError is regarded as failing to store
+func (r *IngestErrors) Add(buildDir string, err error) {
+	e := &BuildError{}
+	if !errors.As(err, &e) {
+		e = &BuildError{Path: buildDir, Stage: StageStore, Err: err}
+	}
+	r.mutex.Lock()
+	replaced := false
+	for i, prev := range r.errors {
+		if prev.Path == e.Path {
+			r.errors[i] = e
+			replaced = true
+		}
+	}
+	if !replaced {
+		r.errors = append(r.errors, e)
+	}
+	r.mutex.Unlock()
+	if r.Recorder == nil {
+		return
+	}
+	jobName, buildNumber := buildDirNames(e.Path)
+	err = r.Recorder.RecordError(builddata.IngestErrorData{
+		Jobname:     jobName,
+		Buildnumber: buildNumber,
+		Path:        e.Path,
+		Stage:       e.Stage,
+		Message:     e.Err.Error(),
+		Time:        int(time.Now().Unix()),
+	})
+	if err != nil {
+		log.Println("ERROR: Cannot record an error of "+e.Path+": ", err)
+	}
+}
+
+// Clear forgets errors of build directories that have been written since they failed,
+// and removes them with 'Recorder' if it's set
+func (r *IngestErrors) Clear(buildDirs []string) {
+	isWritten := make(map[string]bool)
+	for _, buildDir := range buildDirs {
+		isWritten[buildDir] = true
+	}
+	r.mutex.Lock()
+	kept := []*BuildError{}
+	for _, e := range r.errors {
+		if !isWritten[e.Path] {
+			kept = append(kept, e)
+		}
+	}
+	r.errors = kept
+	r.mutex.Unlock()
+	if r.Recorder == nil {
+		return
+	}
+	if err := r.Recorder.ClearErrors(buildDirs); err != nil {
+		log.Println("ERROR: Cannot clear errors of written builds: ", err)
+	}
+}
+
+// Len returns a number of failed builds
+func (r *IngestErrors) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.errors)
+}
+
+// Summary returns a line like '3 builds failed (parse: 2, store: 1)' and an error of each build
+// sorted by paths
+func (r *IngestErrors) Summary() (string, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	byStage := make(map[string]int)
+	lines := []string{}
+	for _, e := range r.errors {
+		byStage[e.Stage]++
+		lines = append(lines, e.Error())
+	}
+	sort.Strings(lines)
+	stages := []string{}
+	for _, stage := range []string{StageRead, StageParse, StageStore} {
+		if byStage[stage] > 0 {
+			stages = append(stages, fmt.Sprintf("%s: %d", stage, byStage[stage]))
+		}
+	}
+	return fmt.Sprintf("%d builds failed (%s)", len(r.errors), strings.Join(stages, ", ")), lines
+}
+
+// ExitStatus reports failed builds and returns an exit status of a run, which is 1 if builds couldn't be
+// written ('writeFailed'), some builds failed, or it was interrupted before all builds were analyzed
+func ExitStatus(failures *IngestErrors, writeFailed bool, interrupted bool) int {
+	status := 0
+	if writeFailed {
+		status = 1
+	}
+	if failures.Report() {
+		status = 1
+	}
+	if interrupted {
+		log.Println("WARNING: Interrupted before all builds were analyzed")
+		status = 1
+	}
+	return status
+}
+
+// Report logs failed builds and a summary, and returns true if any build failed
+func (r *IngestErrors) Report() bool {
+	if r.Len() == 0 {
+		return false
+	}
+	summary, lines := r.Summary()
+	for _, line := range lines {
+		log.Println("ERROR: Failed build: " + line)
+	}
+	log.Println("ERROR: " + summary)
+	return true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"os"
+)
+
+func ExampleBuildError() {
+	var err error = &BuildError{Path: samplesJobsDir + "/tools/builds/9", Stage: StageRead, Err: os.ErrNotExist}
+	fmt.Println(err)
+	fmt.Println(errors.Is(err, os.ErrNotExist))
+	e := &BuildError{}
+	fmt.Println(errors.As(fmt.Errorf("wrapped: %w", err), &e), e.Stage)
+	// Output:
+	// read ../samples/jenkins_home/jobs/tools/builds/9: file does not exist
+	// true
+	// true read
+}
+
+// errorRecorder keeps errors on memory
+type errorRecorder struct {
+	errors map[string]string
+}
+
+func (r *errorRecorder) RecordError(e builddata.IngestErrorData) error {
+	r.errors[e.Path] = fmt.Sprintf("%s #%d %s", e.Jobname, e.Buildnumber, e.Stage)
+	return nil
+}
+
+func (r *errorRecorder) ClearErrors(paths []string) error {
+	for _, path := range paths {
+		delete(r.errors, path)
+	}
+	return nil
+}
+
+func ExampleIngestErrors_Summary() {
+	recorder := &errorRecorder{errors: make(map[string]string)}
+	failures := &IngestErrors{Recorder: recorder}
+	failures.Add("/jenkins/jobs/tools/builds/3", &BuildError{Path: "/jenkins/jobs/tools/builds/3", Stage: StageParse, Err: errors.New("EOF")})
+	failures.Add("/jenkins/jobs/tools/builds/1", &BuildError{Path: "/jenkins/jobs/tools/builds/1", Stage: StageParse, Err: errors.New("EOF")})
+	// An error that isn't a BuildError is of storing a build
+	failures.Add("/jenkins/jobs/tools/builds/2", errors.New("not primary"))
+	// A build that fails again on a run keeps its last error
+	failures.Add("/jenkins/jobs/tools/builds/1", errors.New("not primary"))
+	summary, lines := failures.Summary()
+	fmt.Println(summary)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Println(recorder.errors["/jenkins/jobs/tools/builds/2"])
+
+	// Builds that have been written since they failed are cleared
+	failures.Clear([]string{"/jenkins/jobs/tools/builds/2", "/jenkins/jobs/tools/builds/3"})
+	summary, _ = failures.Summary()
+	fmt.Println(summary, len(recorder.errors))
+	// Output:
+	// 3 builds failed (parse: 1, store: 2)
+	// parse /jenkins/jobs/tools/builds/3: EOF
+	// store /jenkins/jobs/tools/builds/1: not primary
+	// store /jenkins/jobs/tools/builds/2: not primary
+	// tools #2 store
+	// 1 builds failed (store: 1) 1
+}
+
+func ExampleExitStatus() {
+	fmt.Println(ExitStatus(&IngestErrors{}, false, false))
+	fmt.Println(ExitStatus(&IngestErrors{}, true, false))
+	fmt.Println(ExitStatus(&IngestErrors{}, false, true))
+	failures := &IngestErrors{}
+	failures.Add("/jenkins/jobs/tools/builds/1", &BuildError{Path: "/jenkins/jobs/tools/builds/1", Stage: StageParse, Err: errors.New("EOF")})
+	fmt.Println(ExitStatus(failures, false, false))
+	// Output:
+	// 0
+	// 1
+	// 1
+	// 1
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/jobstate"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GapDeleted     = "deleted"      // stored, but its directory has been deleted
+	GapNotIngested = "not-ingested" // its directory exists, but it isn't stored
+	GapMissing     = "missing"      // neither stored nor on disk. It was never built or deleted before stored
+)
+
+// Gap is a range of build numbers that aren't stored with valid sources
+type Gap struct {
+	From int
+	To   int
+	Kind string
+}
+
+// jobMachine returns a machine name from a job name like 'starfish-drd4tv-official-h15'
+func jobMachine(jobName string) string {
+	arr := strings.Split(jobName, "-")
+	if len(arr) < 4 {
+		return ""
+	}
+	return arr[3]
+}
+
+func NewJobData(jobDir string) (builddata.JobData, error) {
+	jobName := filepath.Base(jobDir)
+	data := builddata.JobData{
+		Jobname:    jobName,
+		Machine:    jobMachine(jobName),
+		Permalinks: bson.M{},
+		Updated:    int(time.Now().Unix()),
+	}
+	next, err := jobstate.ReadNextBuildNumber(jobDir)
+	if err != nil {
+		return data, err
+	}
+	data.Next_buildnumber = next
+	permalinks, err := jobstate.ReadPermalinks(jobDir + "/builds")
+	if err != nil {
+		return data, err
+	}
+	for k, v := range permalinks {
+		data.Permalinks[k] = v
+	}
+	return data, nil
+}
+
+// StoreJob upserts a state of a job keyed by a job name
+func StoreJob(store sink.Store, jobDir string) error {
+	data, err := NewJobData(jobDir)
+	if err != nil {
+		return err
+	}
+	return store.UpsertJob(data)
+}
+
+// JobStates stores states of jobs whose builds have been written. A job is stored once
+// for builds of a call, and isn't stored again while its state stays the same.
+// It's safe for concurrent use
+type JobStates struct {
+	Store sink.Store
+
+	mutex  sync.Mutex
+	stored map[string]builddata.JobData
+}
+
+// StoreJobs stores states of jobs of build directories
+func (s *JobStates) StoreJobs(buildDirs []string) {
+	jobDirs := make(map[string]bool)
+	for _, buildDir := range buildDirs {
+		jobDirs[filepath.Dir(filepath.Dir(buildDir))] = true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stored == nil {
+		s.stored = make(map[string]builddata.JobData)
+	}
+	for jobDir := range jobDirs {
+		data, err := NewJobData(jobDir)
+		if err != nil {
+			log.Println("ERROR: Cannot read a job state: ", err)
+			continue
+		}
+		prev, ok := s.stored[data.Jobname]
+		if ok && prev.Next_buildnumber == data.Next_buildnumber && reflect.DeepEqual(prev.Permalinks, data.Permalinks) {
+			continue
+		}
+		if err := s.Store.UpsertJob(data); err != nil {
+			log.Println("ERROR: Cannot store a job state: ", err)
+			continue
+		}
+		s.stored[data.Jobname] = data
+	}
+}
+
+// FindGaps returns ranges of build numbers below 'nextBuildNumber' of a job
+// that aren't stored or whose directories have been deleted
+func FindGaps(jobDir string, store sink.Store, nextBuildNumber int) ([]Gap, error) {
+	jobName := filepath.Base(jobDir)
+	stored, err := store.StoredBuilds(jobName)
+	if err != nil {
+		return nil, err
+	}
+	isStored := make(map[int]bool)
+	isDeleted := make(map[int]bool)
+	for _, v := range stored {
+		isStored[v.Buildnumber] = true
+		isDeleted[v.Buildnumber] = v.Source_deleted
+	}
+	onDisk := make(map[int]bool)
+	builds, _ := ioutil.ReadDir(jobDir + "/builds")
+	for _, build := range builds {
+		if build.IsDir() && validBuildNumber.MatchString(build.Name()) {
+			n, _ := strconv.Atoi(build.Name())
+			onDisk[n] = true
+		}
+	}
+
+	gaps := []Gap{}
+	for n := 1; n < nextBuildNumber; n++ {
+		kind := ""
+		if isStored[n] && (isDeleted[n] || !onDisk[n]) {
+			kind = GapDeleted
+		} else if !isStored[n] && onDisk[n] {
+			kind = GapNotIngested
+		} else if !isStored[n] {
+			kind = GapMissing
+		}
+		if kind == "" {
+			continue
+		}
+		last := len(gaps) - 1
+		if last >= 0 && gaps[last].Kind == kind && gaps[last].To == n-1 {
+			gaps[last].To = n
+		} else {
+			gaps = append(gaps, Gap{From: n, To: n, Kind: kind})
+		}
+	}
+	return gaps, nil
+}
+
+// PrintGaps prints gaps of a job as lines of a job name, a range and a kind of a gap
+func PrintGaps(jobName string, gaps []Gap) {
+	for _, g := range gaps {
+		fmt.Printf("gap,%s,%d-%d,%s\n", jobName, g.From, g.To, g.Kind)
+	}
+}
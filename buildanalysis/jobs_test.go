@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+)
+
+// jobCounter counts jobs that are upserted
+type jobCounter struct {
+	sink.Store
+	upserted []string
+}
+
+func (c *jobCounter) UpsertJob(j builddata.JobData) error {
+	c.upserted = append(c.upserted, fmt.Sprintf("%s next=%d", j.Jobname, j.Next_buildnumber))
+	return nil
+}
+
+func ExampleJobStates() {
+	store := &jobCounter{}
+	jobStates := &JobStates{Store: store}
+	// Builds of a batch store their job once
+	jobStates.StoreJobs([]string{
+		samplesJobsDir + "/starfish-drd4tv-official-h15/builds/1",
+		samplesJobsDir + "/starfish-drd4tv-official-h15/builds/2",
+	})
+	// A job whose state hasn't changed isn't stored again
+	jobStates.StoreJobs([]string{
+		samplesJobsDir + "/starfish-drd4tv-official-h15/builds/2",
+		samplesJobsDir + "/tools/builds/1",
+	})
+	for _, line := range store.upserted {
+		fmt.Println(line)
+	}
+	// Output:
+	// starfish-drd4tv-official-h15 next=3
+	// tools next=2
+}
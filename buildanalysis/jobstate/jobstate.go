@@ -0,0 +1,74 @@
+package jobstate
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Names of permalinks that Jenkins keeps for a job
+var PermalinkNames = []string{
+	"lastBuild",
+	"lastStableBuild",
+	"lastSuccessfulBuild",
+	"lastFailedBuild",
+	"lastUnstableBuild",
+	"lastUnsuccessfulBuild",
+	"lastCompletedBuild",
+}
+
+// ReadNextBuildNumber reads 'nextBuildNumber' on a job directory
+func ReadNextBuildNumber(jobDir string) (int, error) {
+	dat, err := ioutil.ReadFile(jobDir + "/nextBuildNumber")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(dat)))
+}
+
+// ReadPermalinks reads 'permalinks' on a builds directory that has lines like
+//
+//	lastSuccessfulBuild 297
+//	lastFailedBuild -1
+//
+// Older Jenkins makes symbolic links to build directories instead,
+// and they are used if the file doesn't exist.
+// A permalink that doesn't point any build is -1
+func ReadPermalinks(buildsDir string) (map[string]int, error) {
+	permalinks := make(map[string]int)
+	f, err := os.Open(buildsDir + "/permalinks")
+	if os.IsNotExist(err) {
+		for _, name := range PermalinkNames {
+			target, err := os.Readlink(buildsDir + "/" + name)
+			if err != nil {
+				continue
+			}
+			n, err := strconv.Atoi(filepath.Base(target))
+			if err != nil {
+				continue
+			}
+			permalinks[name] = n
+		}
+		return permalinks, nil
+	}
+	if err != nil {
+		return permalinks, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		permalinks[fields[0]] = n
+	}
+	return permalinks, scanner.Err()
+}
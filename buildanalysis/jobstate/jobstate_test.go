@@ -0,0 +1,28 @@
+package jobstate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func ExampleReadPermalinks() {
+	dir, err := ioutil.TempDir("", "job")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "builds"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "nextBuildNumber"), []byte("298\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "builds", "permalinks"), []byte("lastFailedBuild -1\nlastSuccessfulBuild 297\n"), 0644)
+
+	next, err := ReadNextBuildNumber(dir)
+	fmt.Println(next, err)
+	permalinks, err := ReadPermalinks(filepath.Join(dir, "builds"))
+	fmt.Println(permalinks["lastSuccessfulBuild"], permalinks["lastFailedBuild"], err)
+	// Output:
+	// 298 <nil>
+	// 297 -1 <nil>
+}
@@ -0,0 +1,97 @@
+package junitresult
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+const (
+	StatusPassed  = "PASSED"
+	StatusFailed  = "FAILED"
+	StatusSkipped = "SKIPPED"
+)
+
+type Case struct {
+	Duration        float64 `xml:"duration"`
+	ClassName       string  `xml:"className"`
+	TestName        string  `xml:"testName"`
+	Skipped         bool    `xml:"skipped"`
+	SkippedMessage  string  `xml:"skippedMessage"`
+	ErrorStackTrace string  `xml:"errorStackTrace"`
+	ErrorDetails    string  `xml:"errorDetails"`
+	FailedSince     int     `xml:"failedSince"`
+}
+
+type Suite struct {
+	File      string  `xml:"file"`
+	Name      string  `xml:"name"`
+	Duration  float64 `xml:"duration"`
+	Timestamp string  `xml:"timestamp"`
+	Cases     []Case  `xml:"cases>case"`
+}
+
+// Result is the content of 'junitResult.xml' that Jenkins stores
+// on a build directory when a job publishes JUnit reports
+type Result struct {
+	XMLName  xml.Name `xml:"result"`
+	Suites   []Suite  `xml:"suites>suite"`
+	Duration float64  `xml:"duration"`
+}
+
+type Summary struct {
+	Total    int
+	Passed   int
+	Failed   int
+	Skipped  int
+	Duration float64
+}
+
+func Parse(dat []byte) (Result, error) {
+	v := Result{}
+	// Jenkins writes XML 1.1 headers that encoding/xml doesn't accept
+	dat = []byte(strings.Replace(string(dat), "<?xml version='1.1'", "<?xml version='1.0'", 1))
+	err := xml.Unmarshal(dat, &v)
+	return v, err
+}
+
+func (c Case) Status() string {
+	if c.Skipped {
+		return StatusSkipped
+	}
+	if c.ErrorStackTrace != "" || c.ErrorDetails != "" {
+		return StatusFailed
+	}
+	return StatusPassed
+}
+
+// Message returns an error message for a failed case or a reason for a skipped case
+func (c Case) Message() string {
+	switch c.Status() {
+	case StatusSkipped:
+		return c.SkippedMessage
+	case StatusFailed:
+		if c.ErrorDetails != "" {
+			return c.ErrorDetails
+		}
+		return strings.SplitN(c.ErrorStackTrace, "\n", 2)[0]
+	}
+	return ""
+}
+
+func (v Result) Summary() Summary {
+	s := Summary{Duration: v.Duration}
+	for _, suite := range v.Suites {
+		for _, c := range suite.Cases {
+			s.Total++
+			switch c.Status() {
+			case StatusPassed:
+				s.Passed++
+			case StatusFailed:
+				s.Failed++
+			case StatusSkipped:
+				s.Skipped++
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package junitresult
+
+import (
+	"fmt"
+)
+
+const sampleResult = `<?xml version='1.1' encoding='UTF-8'?>
+<result plugin="junit@1.26.1">
+  <suites>
+    <suite>
+      <file>/tmp/ptest/results.xml</file>
+      <name>ptest.glib-2.0</name>
+      <duration>3.5</duration>
+      <cases>
+        <case>
+          <duration>1.5</duration>
+          <className>glib-2.0</className>
+          <testName>gvariant</testName>
+          <skipped>false</skipped>
+          <failedSince>0</failedSince>
+        </case>
+        <case>
+          <duration>2.0</duration>
+          <className>glib-2.0</className>
+          <testName>gdbus-connection</testName>
+          <skipped>false</skipped>
+          <errorStackTrace>timeout
+at line 1</errorStackTrace>
+          <failedSince>296</failedSince>
+        </case>
+        <case>
+          <duration>0.0</duration>
+          <className>glib-2.0</className>
+          <testName>gmenumodel</testName>
+          <skipped>true</skipped>
+          <skippedMessage>no dbus</skippedMessage>
+          <failedSince>0</failedSince>
+        </case>
+      </cases>
+    </suite>
+  </suites>
+  <duration>3.5</duration>
+</result>`
+
+func ExampleParse() {
+	v, err := Parse([]byte(sampleResult))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, c := range v.Suites[0].Cases {
+		fmt.Printf("%s %s %q\n", c.TestName, c.Status(), c.Message())
+	}
+	s := v.Summary()
+	fmt.Println(s.Total, s.Passed, s.Failed, s.Skipped, s.Duration)
+	// Output:
+	// gvariant PASSED ""
+	// gdbus-connection FAILED "timeout"
+	// gmenumodel SKIPPED "no dbus"
+	// 3 1 1 1 3.5
+}
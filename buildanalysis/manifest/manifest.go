@@ -0,0 +1,138 @@
+package manifest
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	TypeImage   = "image"
+	TypeLicense = "license"
+)
+
+// Package is an entry of a Yocto manifest.
+// An image manifest has Name, Arch and Version per line like
+//
+//	busybox cortexa9t2hf-neon 1.29.3-r0
+//
+// and a license manifest has Name, Version, Recipe and License per block like
+//
+//	PACKAGE NAME: busybox
+//	PACKAGE VERSION: 1.29.3
+//	RECIPE NAME: busybox
+//	LICENSE: GPLv2 & bzip2-1.0.6
+type Package struct {
+	Name    string
+	Arch    string
+	Version string
+	Recipe  string
+	License string
+}
+
+type Change struct {
+	Name string
+	Arch string
+	Old  string
+	New  string
+}
+
+// IsManifest checks if a file name looks like a manifest file
+func IsManifest(name string) bool {
+	return strings.HasSuffix(name, ".manifest")
+}
+
+// TypeOf returns a type of a manifest file from its name
+func TypeOf(name string) string {
+	if strings.Contains(path.Base(name), "license") {
+		return TypeLicense
+	}
+	return TypeImage
+}
+
+func ParseImage(r io.Reader) ([]Package, error) {
+	list := []Package{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		list = append(list, Package{Name: fields[0], Arch: fields[1], Version: fields[2]})
+	}
+	return list, scanner.Err()
+}
+
+func ParseLicense(r io.Reader) ([]Package, error) {
+	list := []Package{}
+	p := Package{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "PACKAGE NAME":
+			if p.Name != "" {
+				list = append(list, p)
+			}
+			p = Package{Name: value}
+		case "PACKAGE VERSION":
+			p.Version = value
+		case "RECIPE NAME":
+			p.Recipe = value
+		case "LICENSE":
+			p.License = value
+		}
+	}
+	if p.Name != "" {
+		list = append(list, p)
+	}
+	return list, scanner.Err()
+}
+
+func Parse(name string, r io.Reader) ([]Package, error) {
+	if TypeOf(name) == TypeLicense {
+		return ParseLicense(r)
+	}
+	return ParseImage(r)
+}
+
+// Diff returns packages whose versions differ between 'old' and 'new'.
+// An added package has an empty Old and a removed one has an empty New
+func Diff(old, new []Package) []Change {
+	key := func(p Package) string { return p.Name + "\x00" + p.Arch }
+	oldMap := make(map[string]Package)
+	for _, p := range old {
+		oldMap[key(p)] = p
+	}
+	newMap := make(map[string]Package)
+	for _, p := range new {
+		newMap[key(p)] = p
+	}
+	changes := []Change{}
+	for k, o := range oldMap {
+		n, ok := newMap[k]
+		if !ok {
+			changes = append(changes, Change{Name: o.Name, Arch: o.Arch, Old: o.Version})
+		} else if n.Version != o.Version {
+			changes = append(changes, Change{Name: o.Name, Arch: o.Arch, Old: o.Version, New: n.Version})
+		}
+	}
+	for k, n := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			changes = append(changes, Change{Name: n.Name, Arch: n.Arch, New: n.Version})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Name != changes[j].Name {
+			return changes[i].Name < changes[j].Name
+		}
+		return changes[i].Arch < changes[j].Arch
+	})
+	return changes
+}
@@ -0,0 +1,43 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+func ExampleParseLicense() {
+	list, _ := ParseLicense(strings.NewReader(`PACKAGE NAME: busybox
+PACKAGE VERSION: 1.29.3
+RECIPE NAME: busybox
+LICENSE: GPLv2 & bzip2-1.0.6
+
+PACKAGE NAME: base-files
+PACKAGE VERSION: 3.0.14
+RECIPE NAME: base-files
+LICENSE: GPLv2
+`))
+	for _, p := range list {
+		fmt.Println(p.Name, p.Version, p.License)
+	}
+	// Output:
+	// busybox 1.29.3 GPLv2 & bzip2-1.0.6
+	// base-files 3.0.14 GPLv2
+}
+
+func ExampleDiff() {
+	old, _ := ParseImage(strings.NewReader(`base-files k7hp 3.0.14-r89
+busybox cortexa9t2hf-neon 1.29.3-r0
+luna-surfacemanager cortexa9t2hf-neon 2.0.0-1+gitr0+a1b2c3-r7
+`))
+	new, _ := ParseImage(strings.NewReader(`base-files k7hp 3.0.14-r89
+busybox cortexa9t2hf-neon 1.29.3-r1
+sam cortexa9t2hf-neon 1.0.0-r2
+`))
+	for _, c := range Diff(old, new) {
+		fmt.Printf("%s %q %q\n", c.Name, c.Old, c.New)
+	}
+	// Output:
+	// busybox "1.29.3-r0" "1.29.3-r1"
+	// luna-surfacemanager "2.0.0-1+gitr0+a1b2c3-r7" ""
+	// sam "" "1.0.0-r2"
+}
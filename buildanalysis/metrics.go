@@ -0,0 +1,227 @@
+package main
+
+import (
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Metrics exposes builds and the analyzer itself as Prometheus metrics.
+// Methods on a nil *Metrics do nothing, so callers don't have to check if metrics are enabled
+type Metrics struct {
+	registry       *prometheus.Registry
+	duration       *prometheus.HistogramVec
+	queueWait      *prometheus.HistogramVec
+	results        *prometheus.CounterVec
+	failureStreak  *prometheus.GaugeVec
+	ingested       *prometheus.CounterVec
+	parseErrors    *prometheus.CounterVec
+	stageBuilds    *prometheus.CounterVec
+	stageBusy      *prometheus.CounterVec
+	lastSuccessAge *prometheus.Desc
+
+	mutex sync.Mutex
+	// jobs: results by build numbers of each job, to find a failure streak regardless of the order
+	// in which routines finish builds
+	// lastSuccess: when the last successful build of each job ended
+	jobs        map[string]map[int]string
+	lastSuccess map[string]time.Time
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "jenkins_build_duration_seconds",
+			Help: "Duration of builds",
+			// 1 minute to about 17 hours
+			Buckets: prometheus.ExponentialBuckets(60, 2, 11),
+		}, []string{"job", "machine"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jenkins_build_queue_wait_seconds",
+			Help:    "Time that builds waited in a queue",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 9),
+		}, []string{"job", "machine"}),
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jenkins_builds_total",
+			Help: "Analyzed builds by results",
+		}, []string{"job", "machine", "result"}),
+		failureStreak: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "jenkins_build_failure_streak",
+			Help: "Number of consecutive failed builds until the latest build of a job",
+		}, []string{"job"}),
+		ingested: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buildanalysis_ingested_builds_total",
+			Help: "Builds that the analyzer has tried to store, by status 'ok' or 'error'",
+		}, []string{"job", "status"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buildanalysis_parse_errors_total",
+			Help: "Builds whose build.xml couldn't be parsed",
+		}, []string{"job"}),
+		stageBuilds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buildanalysis_stage_builds_total",
+			Help: "Builds that each stage of a pipeline has handled",
+		}, []string{"stage"}),
+		stageBusy: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "buildanalysis_stage_busy_seconds_total",
+			Help: "Time that routines of each stage of a pipeline have spent on builds",
+		}, []string{"stage"}),
+		lastSuccessAge: prometheus.NewDesc("jenkins_build_last_success_age_seconds",
+			"Seconds since the last successful build of a job ended", []string{"job"}, nil),
+		jobs:        make(map[string]map[int]string),
+		lastSuccess: make(map[string]time.Time),
+	}
+	m.registry.MustRegister(m.duration, m.queueWait, m.results, m.failureStreak, m.ingested, m.parseErrors, m.stageBuilds, m.stageBusy, m)
+	return m
+}
+
+// Describe and Collect make Metrics a collector of 'lastSuccessAge', which changes as time goes by
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.lastSuccessAge
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for job, t := range m.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(m.lastSuccessAge, prometheus.GaugeValue, time.Since(t).Seconds(), job)
+	}
+}
+
+func buildEnd(v builddata.BuildData) time.Time {
+	return time.Unix(int64(v.Start+v.Duration), 0)
+}
+
+// failureStreak counts FAILURE builds from the latest build of a job
+func failureStreak(results map[int]string) int {
+	latest := 0
+	for n := range results {
+		if n > latest {
+			latest = n
+		}
+	}
+	streak := 0
+	for n := latest; n > 0; n-- {
+		result, ok := results[n]
+		if !ok {
+			continue
+		}
+		if result != "FAILURE" {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+func (m *Metrics) record(v builddata.BuildData) {
+	if m.jobs[v.Jobname] == nil {
+		m.jobs[v.Jobname] = make(map[int]string)
+	}
+	m.jobs[v.Jobname][v.Buildnumber] = v.Result
+	m.failureStreak.WithLabelValues(v.Jobname).Set(float64(failureStreak(m.jobs[v.Jobname])))
+	if v.Result == "SUCCESS" && v.Start != 0 && buildEnd(v).After(m.lastSuccess[v.Jobname]) {
+		m.lastSuccess[v.Jobname] = buildEnd(v)
+	}
+}
+
+// Load sets failure streaks and last successful builds from stored builds,
+// so they are known before any new build is analyzed
+func (m *Metrics) Load(stored []builddata.BuildData) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, v := range stored {
+		if v.Provisional || v.Result == "" {
+			continue
+		}
+		m.record(v)
+	}
+}
+
+// ObserveBuild adds a finished build that has been analyzed.
+// A build that has been observed or loaded before is analyzed again by '-reprocess' or '-detectChanges',
+// so its duration isn't observed again and its result is counted only if it has changed
+func (m *Metrics) ObserveBuild(v builddata.BuildData) {
+	if m == nil || v.Provisional {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result, seen := m.jobs[v.Jobname][v.Buildnumber]
+	if !seen {
+		m.duration.WithLabelValues(v.Jobname, v.Machine).Observe(float64(v.Duration))
+		m.queueWait.WithLabelValues(v.Jobname, v.Machine).Observe(v.Duration_in_queue)
+	}
+	if !seen || result != v.Result {
+		m.results.WithLabelValues(v.Jobname, v.Machine, v.Result).Inc()
+	}
+	m.record(v)
+}
+
+// ObserveIngest counts a build that has been stored, or failed to be stored with 'err'
+func (m *Metrics) ObserveIngest(jobName string, err error) {
+	if m == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.ingested.WithLabelValues(jobName, status).Inc()
+}
+
+func (m *Metrics) ObserveParseError(jobName string) {
+	if m == nil {
+		return
+	}
+	m.parseErrors.WithLabelValues(jobName).Inc()
+}
+
+// ObserveStage counts a build that a stage of a pipeline has handled in 'd'
+func (m *Metrics) ObserveStage(stage string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.stageBuilds.WithLabelValues(stage).Inc()
+	m.stageBusy.WithLabelValues(stage).Add(d.Seconds())
+}
+
+// WatchQueue exposes a number of builds waiting for a stage, which is read when metrics are scraped
+func (m *Metrics) WatchQueue(stage string, depth func() int) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "buildanalysis_stage_queue_depth",
+		Help:        "Builds waiting for each stage of a pipeline",
+		ConstLabels: prometheus.Labels{"stage": stage},
+	}, func() float64 {
+		return float64(depth())
+	}))
+}
+
+// Serve listens on 'addr' and exposes metrics on '/metrics' in the background.
+// It returns an error if it can't listen, so a run fails before it analyzes any build
+func (m *Metrics) Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	log.Println("INFO: Serving metrics on ", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Println("ERROR: Stopped serving metrics: ", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Example_failureStreak() {
+	fmt.Println(failureStreak(map[int]string{1: "SUCCESS", 2: "FAILURE", 3: "FAILURE"}))
+	// Builds that aren't known, like running ones, don't break a streak
+	fmt.Println(failureStreak(map[int]string{1: "SUCCESS", 2: "FAILURE", 4: "FAILURE"}))
+	fmt.Println(failureStreak(map[int]string{1: "FAILURE", 2: "ABORTED", 3: "FAILURE"}))
+	fmt.Println(failureStreak(map[int]string{1: "FAILURE", 2: "SUCCESS"}))
+	fmt.Println(failureStreak(map[int]string{}))
+	// Output:
+	// 2
+	// 2
+	// 1
+	// 0
+	// 0
+}
+
+func ExampleMetrics_Load() {
+	job := "starfish-drd4tv-official-h15"
+	m := NewMetrics()
+	m.Load([]builddata.BuildData{
+		{Jobname: job, Buildnumber: 1, Result: "SUCCESS", Start: 1551657600, Duration: 3600},
+		{Jobname: job, Buildnumber: 2, Result: "FAILURE"},
+		// A running build isn't loaded
+		{Jobname: job, Buildnumber: 3, Provisional: true},
+	})
+	fmt.Println(testutil.ToFloat64(m.failureStreak.WithLabelValues(job)))
+	fmt.Println(m.lastSuccess[job].Unix())
+	// Loaded builds aren't counted
+	fmt.Println(testutil.CollectAndCount(m.results))
+	// Output:
+	// 1
+	// 1551661200
+	// 0
+}
+
+func ExampleMetrics_ObserveBuild() {
+	job := "starfish-drd4tv-official-h15"
+	m := NewMetrics()
+	m.Load([]builddata.BuildData{{Jobname: job, Buildnumber: 1, Result: "SUCCESS"}})
+	// A build that is analyzed again is counted only if its result has changed
+	m.ObserveBuild(builddata.BuildData{Jobname: job, Buildnumber: 1, Result: "SUCCESS"})
+	m.ObserveBuild(builddata.BuildData{Jobname: job, Buildnumber: 2, Result: "FAILURE"})
+	m.ObserveBuild(builddata.BuildData{Jobname: job, Buildnumber: 2, Result: "FAILURE"})
+	m.ObserveBuild(builddata.BuildData{Jobname: job, Buildnumber: 1, Result: "UNSTABLE"})
+	for _, result := range []string{"SUCCESS", "FAILURE", "UNSTABLE"} {
+		fmt.Println(result, testutil.ToFloat64(m.results.WithLabelValues(job, "", result)))
+	}
+	fmt.Println(testutil.CollectAndCount(m.duration))
+	fmt.Println(testutil.ToFloat64(m.failureStreak.WithLabelValues(job)))
+	// Output:
+	// SUCCESS 0
+	// FAILURE 1
+	// UNSTABLE 1
+	// 1
+	// 1
+}
+
+func ExampleMetrics_Serve() {
+	m := NewMetrics()
+	fmt.Println(m.Serve("127.0.0.1:-1") != nil)
+	// Output:
+	// true
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/oebuildjobs"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pipeline analyzes builds in stages, each with its own routines, connected by bounded channels:
+// discover → read → parse → transform → write.
+// A stage waits when a next one is behind, so I/O-bound reads on NFS don't pile up builds in memory
+// and CPU-bound parsing doesn't take slots of reads. Logs that have been read and aren't parsed yet
+// are also bounded by their sizes, so a queue of large logs doesn't run out of memory.
+// Discovery is done by a caller that sends build directories to Run
+type Pipeline struct {
+	Store      sink.Sink
+	Opts       AnalyzeOptions
+	Metrics    *Metrics
+	Failures   *IngestErrors
+	Checkpoint *Checkpoint
+	// Skip is called by the read stage, and a build isn't analyzed if it returns true
+	Skip func(buildDir string) bool
+	// Written is called with build directories of every batch that has been flushed
+	Written func(buildDirs []string)
+
+	// Readers, Parsers and Transformers are numbers of routines of stages. 1 if it's 0.
+	// Builds are written by a single routine
+	Readers      int
+	Parsers      int
+	Transformers int
+	// QueueSize is a capacity of channels between stages. 64 if it's 0
+	QueueSize int
+	// QueueBytes is a size of build files that can be read before they are parsed. 256 MiB if it's 0.
+	// A build larger than QueueBytes is read only once nothing else is waiting
+	QueueBytes int64
+	// Builds are flushed once there are 'BatchSize' of them (100 if it's 0),
+	// or every 'FlushInterval' (10 seconds if it's 0)
+	BatchSize     int
+	FlushInterval time.Duration
+	// StatsInterval is how often stages are reported while running. Only at the end if it's 0
+	StatsInterval time.Duration
+}
+
+type parsedBuild struct {
+	dir string
+	v   oebuildjobs.BuildInfo
+	b   map[string]string
+}
+
+type transformedBuild struct {
+	dir    string
+	record sink.Record
+}
+
+// StageStats counts builds that a stage has handled and time that its routines have spent on them.
+// Queue is a number of builds waiting for the stage
+type StageStats struct {
+	Name     string
+	Workers  int
+	Queue    func() int
+	Capacity int
+
+	builds int64
+	busy   int64
+}
+
+func (s *StageStats) observe(start time.Time, metrics *Metrics) {
+	d := time.Since(start)
+	atomic.AddInt64(&s.builds, 1)
+	atomic.AddInt64(&s.busy, int64(d))
+	metrics.ObserveStage(s.Name, d)
+}
+
+// Snapshot returns a number of builds and busy time so far
+func (s *StageStats) Snapshot() (builds int64, busy time.Duration) {
+	return atomic.LoadInt64(&s.builds), time.Duration(atomic.LoadInt64(&s.busy))
+}
+
+// Line returns a report of a stage over 'elapsed' since 'prev', like
+// 'parse: 1200 builds, 40.0/s, busy 87%, queue 3/64'. Busy is a ratio of time that routines have spent
+// on builds, so a stage that is always busy with a full queue needs more routines
+func (s *StageStats) Line(prev StageSnapshot, elapsed time.Duration) string {
+	builds, busy := s.Snapshot()
+	rate := 0.0
+	busyRatio := 0.0
+	if elapsed > 0 {
+		rate = float64(builds-prev.Builds) / elapsed.Seconds()
+		busyRatio = float64(busy-prev.Busy) / float64(elapsed) / float64(s.Workers)
+	}
+	return fmt.Sprintf("%s: %d builds, %.1f/s, busy %.0f%%, queue %d/%d",
+		s.Name, builds, rate, busyRatio*100, s.Queue(), s.Capacity)
+}
+
+// StageSnapshot is a number of builds and busy time of a stage at a time
+type StageSnapshot struct {
+	Builds int64
+	Busy   time.Duration
+}
+
+// byteBudget bounds bytes of builds that are in memory between stages
+type byteBudget struct {
+	limit int64
+	used  int64
+	mutex sync.Mutex
+	cond  *sync.Cond
+}
+
+func newByteBudget(limit int64) *byteBudget {
+	b := &byteBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// acquire waits until 'n' bytes fit in a budget, or nothing else is in memory
+func (b *byteBudget) acquire(n int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used -= n
+	b.cond.Broadcast()
+}
+
+// buildSize returns a size of a build log and build.xml of a build directory
+func buildSize(buildDir string) int64 {
+	var size int64
+	for _, name := range []string{"/log", "/build.xml"} {
+		if fi, err := os.Stat(buildDir + name); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// start runs 'n' routines of 'worker' and calls 'done' once all of them return
+func start(n int, worker func(), done func()) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		done()
+	}()
+}
+
+func (p *Pipeline) fail(buildDir string, err error) {
+	log.Println("ERROR: Cannot ingest a build: ", err)
+	p.Failures.Add(buildDir, err)
+	jobName, _ := buildDirNames(buildDir)
+	if e, ok := err.(*BuildError); ok && e.Stage == StageParse {
+		p.Metrics.ObserveParseError(jobName)
+	}
+	p.Metrics.ObserveIngest(jobName, err)
+}
+
+// Run analyzes builds from 'builds' until it's closed, and returns once every build has been written.
+// After 'ctx' is cancelled, builds that are left on 'builds' are skipped,
+// and builds that have been read go through the other stages.
+// It returns an error if builds couldn't be flushed
+func (p *Pipeline) Run(ctx context.Context, builds <-chan string) error {
+	if p.Readers == 0 {
+		p.Readers = 1
+	}
+	if p.Parsers == 0 {
+		p.Parsers = 1
+	}
+	if p.Transformers == 0 {
+		p.Transformers = 1
+	}
+	if p.QueueSize == 0 {
+		p.QueueSize = 64
+	}
+	if p.QueueBytes == 0 {
+		p.QueueBytes = 256 << 20
+	}
+	if p.BatchSize == 0 {
+		p.BatchSize = 100
+	}
+	if p.FlushInterval == 0 {
+		p.FlushInterval = 10 * time.Second
+	}
+	if p.Failures == nil {
+		p.Failures = &IngestErrors{}
+	}
+	read := make(chan BuildFiles, p.QueueSize)
+	budget := newByteBudget(p.QueueBytes)
+	parsed := make(chan parsedBuild, p.QueueSize)
+	transformed := make(chan transformedBuild, p.QueueSize)
+	stats := []*StageStats{
+		{Name: "read", Workers: p.Readers, Queue: func() int { return len(builds) }, Capacity: cap(builds)},
+		{Name: "parse", Workers: p.Parsers, Queue: func() int { return len(read) }, Capacity: p.QueueSize},
+		{Name: "transform", Workers: p.Transformers, Queue: func() int { return len(parsed) }, Capacity: p.QueueSize},
+		{Name: "write", Workers: 1, Queue: func() int { return len(transformed) }, Capacity: p.QueueSize},
+	}
+	for _, s := range stats {
+		p.Metrics.WatchQueue(s.Name, s.Queue)
+	}
+	readStats, parseStats, transformStats, writeStats := stats[0], stats[1], stats[2], stats[3]
+
+	start(p.Readers, func() {
+		for buildDir := range builds {
+			// Builds left on a channel are analyzed on a next run
+			if ctx.Err() != nil {
+				continue
+			}
+			if p.Skip != nil && p.Skip(buildDir) {
+				continue
+			}
+			// A build is read once its files fit in a budget, and they are released once it's parsed
+			size := buildSize(buildDir)
+			budget.acquire(size)
+			t := time.Now()
+			f, err := ReadBuild(buildDir)
+			readStats.observe(t, p.Metrics)
+			if err != nil {
+				budget.release(size)
+				p.fail(buildDir, err)
+				continue
+			}
+			f.size = size
+			read <- f
+		}
+	}, func() { close(read) })
+
+	start(p.Parsers, func() {
+		for f := range read {
+			t := time.Now()
+			v, b, err := ParseBuild(f)
+			parseStats.observe(t, p.Metrics)
+			budget.release(f.size)
+			if err != nil {
+				p.fail(f.Dir, err)
+				continue
+			}
+			parsed <- parsedBuild{dir: f.Dir, v: v, b: b}
+		}
+	}, func() { close(parsed) })
+
+	start(p.Transformers, func() {
+		for pb := range parsed {
+			t := time.Now()
+			data, testCases, hasTestResult := TransformBuild(pb.dir, pb.v, pb.b, p.Opts)
+			transformStats.observe(t, p.Metrics)
+			transformed <- transformedBuild{
+				dir:    pb.dir,
+				record: sink.Record{Build: data, TestCases: testCases, HasTestResult: hasTestResult, Path: pb.dir},
+			}
+		}
+	}, func() { close(transformed) })
+
+	// Stages are reported every 'StatsInterval' and at the end
+	stopStats := make(chan int)
+	began := time.Now()
+	go func() {
+		if p.StatsInterval == 0 {
+			return
+		}
+		ticker := time.NewTicker(p.StatsInterval)
+		defer ticker.Stop()
+		prev := make([]StageSnapshot, len(stats))
+		last := began
+		for {
+			select {
+			case <-stopStats:
+				return
+			case now := <-ticker.C:
+				for i, s := range stats {
+					log.Println("INFO: Stage " + s.Line(prev[i], now.Sub(last)))
+					prev[i].Builds, prev[i].Busy = s.Snapshot()
+				}
+				last = now
+			}
+		}
+	}()
+	defer func() {
+		close(stopStats)
+		for _, s := range stats {
+			log.Println("INFO: Stage " + s.Line(StageSnapshot{}, time.Since(began)))
+		}
+	}()
+
+	return p.write(transformed, writeStats)
+}
+
+// write upserts builds and flushes them in batches. Builds of a batch are added to a checkpoint
+// and counted as ingested only after they are flushed, and builds that a sink has dropped or couldn't flush are failed instead
+func (p *Pipeline) write(transformed <-chan transformedBuild, writeStats *StageStats) error {
+	var flushErr error
+	batch := []sink.Record{}
+	drop := func(dropped *sink.DroppedError) {
+		isDropped := make(map[string]bool)
+		for _, r := range dropped.Records {
+			isDropped[r.Path] = true
+			p.fail(r.Path, &BuildError{Path: r.Path, Stage: StageStore, Err: dropped})
+		}
+		kept := []sink.Record{}
+		for _, r := range batch {
+			if !isDropped[r.Path] {
+				kept = append(kept, r)
+			}
+		}
+		batch = kept
+	}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.Store.Flush(); err != nil {
+			log.Println("ERROR: Cannot flush builds: ", err)
+			flushErr = err
+			var dropped *sink.DroppedError
+			if errors.As(err, &dropped) {
+				drop(dropped)
+			}
+			// Other builds have been written only if a sink returns a DroppedError itself
+			if _, ok := err.(*sink.DroppedError); !ok {
+				for _, r := range batch {
+					p.fail(r.Path, &BuildError{Path: r.Path, Stage: StageStore, Err: err})
+				}
+				batch = nil
+				return
+			}
+		}
+		buildDirs := make([]string, 0, len(batch))
+		for _, r := range batch {
+			buildDirs = append(buildDirs, r.Path)
+			p.Metrics.ObserveIngest(r.Build.Jobname, nil)
+			p.Metrics.ObserveBuild(r.Build)
+		}
+		if p.Checkpoint != nil {
+			for _, buildDir := range buildDirs {
+				p.Checkpoint.Add(buildDir)
+			}
+			if err := p.Checkpoint.Save(); err != nil {
+				log.Println("ERROR: Cannot save a checkpoint: ", err)
+			}
+		}
+		p.Failures.Clear(buildDirs)
+		if p.Written != nil {
+			p.Written(buildDirs)
+		}
+		batch = nil
+	}
+
+	ticker := time.NewTicker(p.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case tb, ok := <-transformed:
+			if !ok {
+				flush()
+				return flushErr
+			}
+			t := time.Now()
+			err := p.Store.UpsertBuild(tb.record)
+			writeStats.observe(t, p.Metrics)
+			// Builds that have been queued before are dropped, and this build is queued to be written later
+			var dropped *sink.DroppedError
+			if errors.As(err, &dropped) {
+				err = nil
+			}
+			if err != nil {
+				p.fail(tb.dir, &BuildError{Path: tb.dir, Stage: StageStore, Err: err})
+				continue
+			}
+			batch = append(batch, tb.record)
+			if dropped != nil {
+				drop(dropped)
+			}
+			if len(batch) >= p.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
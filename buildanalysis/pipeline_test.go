@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// failingSink is a memory sink whose flushes fail
+type failingSink struct {
+	*sink.MemorySink
+}
+
+func (s failingSink) Flush() error {
+	return errors.New("connection refused")
+}
+
+func sampleBuilds(buildDirs ...string) <-chan string {
+	builds := make(chan string, len(buildDirs))
+	for _, buildDir := range buildDirs {
+		builds <- buildDir
+	}
+	close(builds)
+	return builds
+}
+
+func ExamplePipeline() {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	checkpoint, _ := LoadCheckpoint(filepath.Join(dir, "checkpoint"), "")
+	store := sink.NewMemorySink()
+	p := &Pipeline{
+		Store:      store,
+		Failures:   &IngestErrors{},
+		Checkpoint: checkpoint,
+		// Builds of a batch are on a checkpoint once they are flushed
+		Written: func(buildDirs []string) {
+			for _, buildDir := range buildDirs {
+				fmt.Println("written", filepath.Base(filepath.Dir(filepath.Dir(buildDir))), filepath.Base(buildDir),
+					checkpoint.Done(buildDir))
+			}
+		},
+		BatchSize:  2,
+		QueueBytes: 1,
+	}
+	err = p.Run(context.Background(), sampleBuilds(
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/1",
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/2",
+		samplesJobsDir+"/tools/builds/1",
+		// A build without build.xml fails, and others are written
+		samplesJobsDir+"/tools/builds/9",
+	))
+	fmt.Println(err, store.Flushed, checkpoint.Len(), p.Failures.Len())
+	for _, r := range store.Records() {
+		fmt.Println(r.Build.Jobname, r.Build.Buildnumber, r.Build.Result)
+	}
+	// Output:
+	// written starfish-drd4tv-official-h15 1 true
+	// written starfish-drd4tv-official-h15 2 true
+	// written tools 1 true
+	// <nil> 2 3 1
+	// starfish-drd4tv-official-h15 1 SUCCESS
+	// starfish-drd4tv-official-h15 2 FAILURE
+	// tools 1 SUCCESS
+}
+
+func ExamplePipeline_flushError() {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	checkpoint, _ := LoadCheckpoint(filepath.Join(dir, "checkpoint"), "")
+	written := 0
+	p := &Pipeline{
+		Store:      failingSink{sink.NewMemorySink()},
+		Failures:   &IngestErrors{},
+		Checkpoint: checkpoint,
+		Written:    func(buildDirs []string) { written += len(buildDirs) },
+	}
+	// Builds that couldn't be flushed fail and aren't on a checkpoint, so a next run analyzes them again
+	err = p.Run(context.Background(), sampleBuilds(
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/1",
+		samplesJobsDir+"/tools/builds/1",
+	))
+	fmt.Println(err, written, checkpoint.Done(samplesJobsDir+"/tools/builds/1"), p.Failures.Len())
+	_, err = os.Stat(checkpoint.Path)
+	fmt.Println(os.IsNotExist(err))
+	// Output:
+	// connection refused 0 false 2
+	// true
+}
+
+// bulkSink is a memory sink that writes builds in bulks of two by UpsertBuild, and its first bulk fails
+type bulkSink struct {
+	*sink.MemorySink
+	pending []sink.Record
+	bulks   int
+}
+
+func (s *bulkSink) UpsertBuild(r sink.Record) error {
+	s.pending = append(s.pending, r)
+	if len(s.pending) < 2 {
+		return nil
+	}
+	return s.Flush()
+}
+
+func (s *bulkSink) Flush() error {
+	pending := s.pending
+	s.pending = nil
+	s.bulks++
+	if s.bulks == 1 {
+		return &sink.DroppedError{Records: pending, Err: errors.New("mapper_parsing_exception")}
+	}
+	for _, r := range pending {
+		s.MemorySink.UpsertBuild(r)
+	}
+	return nil
+}
+
+func ExamplePipeline_bulkError() {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	checkpoint, _ := LoadCheckpoint(filepath.Join(dir, "checkpoint"), "")
+	store := &bulkSink{MemorySink: sink.NewMemorySink()}
+	p := &Pipeline{
+		Store:      store,
+		Failures:   &IngestErrors{},
+		Checkpoint: checkpoint,
+		Written: func(buildDirs []string) {
+			for _, buildDir := range buildDirs {
+				fmt.Println("written", filepath.Base(filepath.Dir(filepath.Dir(buildDir))), filepath.Base(buildDir))
+			}
+		},
+		BatchSize: 10,
+	}
+	// Every build of a bulk that failed inside UpsertBuild fails, not only the build being upserted
+	err = p.Run(context.Background(), sampleBuilds(
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/1",
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/2",
+		samplesJobsDir+"/tools/builds/1",
+	))
+	fmt.Println(err, checkpoint.Len(), p.Failures.Len(), len(store.Records()))
+	fmt.Println(checkpoint.Done(samplesJobsDir+"/starfish-drd4tv-official-h15/builds/1"),
+		checkpoint.Done(samplesJobsDir+"/tools/builds/1"))
+	// Output:
+	// written tools 1
+	// <nil> 1 2 1
+	// false true
+}
+
+// droppingSink is a memory sink that drops builds of its first flush
+type droppingSink struct {
+	*sink.MemorySink
+	pending []sink.Record
+	flushed int
+}
+
+func (s *droppingSink) UpsertBuild(r sink.Record) error {
+	s.pending = append(s.pending, r)
+	return s.MemorySink.UpsertBuild(r)
+}
+
+func (s *droppingSink) Flush() error {
+	s.flushed++
+	pending := s.pending
+	s.pending = nil
+	if s.flushed == 1 {
+		return &sink.DroppedError{Records: pending, Err: errors.New("connection refused")}
+	}
+	return nil
+}
+
+func ExamplePipeline_metrics() {
+	m := NewMetrics()
+	p := &Pipeline{Store: &droppingSink{MemorySink: sink.NewMemorySink()}, Metrics: m, BatchSize: 2}
+	p.Run(context.Background(), sampleBuilds(
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/1",
+		samplesJobsDir+"/starfish-drd4tv-official-h15/builds/2",
+		samplesJobsDir+"/tools/builds/1",
+		samplesJobsDir+"/tools/builds/9",
+	))
+	// Builds are counted once they are flushed, and dropped builds only as errors
+	for _, jobName := range []string{"starfish-drd4tv-official-h15", "tools"} {
+		fmt.Println(jobName,
+			testutil.ToFloat64(m.ingested.WithLabelValues(jobName, "ok")),
+			testutil.ToFloat64(m.ingested.WithLabelValues(jobName, "error")))
+	}
+	fmt.Println(testutil.CollectAndCount(m.results))
+	// Output:
+	// starfish-drd4tv-official-h15 0 2
+	// tools 1 1
+	// 1
+}
+
+func ExampleStageStats_Line() {
+	s := &StageStats{Name: "parse", Workers: 2, Queue: func() int { return 3 }, Capacity: 64}
+	atomic.AddInt64(&s.builds, 20)
+	atomic.AddInt64(&s.busy, int64(15*time.Second))
+	fmt.Println(s.Line(StageSnapshot{}, 10*time.Second))
+	// Only builds and busy time since a previous report count
+	fmt.Println(s.Line(StageSnapshot{Builds: 10, Busy: 5 * time.Second}, 10*time.Second))
+	// Output:
+	// parse: 20 builds, 2.0/s, busy 75%, queue 3/64
+	// parse: 20 builds, 1.0/s, busy 50%, queue 3/64
+}
+
+func Example_byteBudget() {
+	b := newByteBudget(100)
+	b.acquire(60)
+	acquired := make(chan int64)
+	go func() {
+		b.acquire(60)
+		acquired <- 60
+	}()
+	select {
+	case <-acquired:
+		fmt.Println("acquired over a budget")
+	case <-time.After(50 * time.Millisecond):
+		fmt.Println("waiting")
+	}
+	b.release(60)
+	fmt.Println(<-acquired)
+	// A build that is larger than a budget is read once nothing else is in memory
+	b.release(60)
+	b.acquire(500)
+	fmt.Println(b.used)
+	// Output:
+	// waiting
+	// 60
+	// 500
+}
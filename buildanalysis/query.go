@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/manifest"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+)
+
+// buildPackages collects packages of a build from its image manifests.
+// License manifests are used only if a build doesn't have any image manifest
+func buildPackages(build builddata.BuildData) []manifest.Package {
+	packages := map[string][]manifest.Package{}
+	for _, m := range build.Manifests {
+		for _, p := range m.Packages {
+			packages[m.Type] = append(packages[m.Type], manifest.Package{
+				Name:    p.Name,
+				Arch:    p.Arch,
+				Version: p.Version,
+				Recipe:  p.Recipe,
+				License: p.License,
+			})
+		}
+	}
+	if len(packages[manifest.TypeImage]) > 0 {
+		return packages[manifest.TypeImage]
+	}
+	return packages[manifest.TypeLicense]
+}
+
+// DiffPackages prints package version changes between two builds of a job.
+// 'buildRange' is two build numbers separated by ':', e.g. '296:297'
+func DiffPackages(store sink.Store, jobName string, buildRange string) error {
+	numbers := ParseMeta(buildRange, ":")
+	if len(numbers) != 2 {
+		return errors.New("Invalid build range: " + buildRange)
+	}
+	builds := make([]builddata.BuildData, 2)
+	for i, n := range numbers {
+		buildNumber, err := strconv.Atoi(n)
+		if err != nil {
+			return errors.New("Invalid build number: " + n)
+		}
+		builds[i], err = store.Build(jobName, buildNumber)
+		if err != nil {
+			return fmt.Errorf("Cannot find build %s #%d: %v", jobName, buildNumber, err)
+		}
+		if len(builds[i].Manifests) == 0 {
+			return fmt.Errorf("Build %s #%d doesn't have any manifest", jobName, buildNumber)
+		}
+	}
+	changes := manifest.Diff(buildPackages(builds[0]), buildPackages(builds[1]))
+	for _, c := range changes {
+		old := c.Old
+		if old == "" {
+			old = "-"
+		}
+		new := c.New
+		if new == "" {
+			new = "-"
+		}
+		fmt.Println(strings.Join([]string{c.Name, c.Arch, old, new}, ","))
+	}
+	return nil
+}
+
+// Consumers prints builds that used files that a build of a job produced.
+// Each line has a file name, its MD5 checksum, a job name and a build number of a consumer
+// and a result of the consumer if it is stored
+func Consumers(store sink.Store, jobName string, buildNumber int) error {
+	fingerprints, err := store.FingerprintsOf(jobName, buildNumber)
+	if err != nil {
+		return err
+	}
+	if len(fingerprints) == 0 {
+		return fmt.Errorf("Build %s #%d doesn't have any fingerprint", jobName, buildNumber)
+	}
+	// Results of consumers are read with a query of build number ranges per job
+	ranges := make(map[string][]interface{})
+	for _, f := range fingerprints {
+		for _, u := range f.Usages {
+			for _, r := range u.Ranges {
+				ranges[u.Jobname] = append(ranges[u.Jobname], bson.M{"buildnumber": bson.M{"$gte": r.Start, "$lte": r.End}})
+			}
+		}
+	}
+	results := make(map[string]map[int]string)
+	for consumerJob, rs := range ranges {
+		builds, err := store.FindBuilds(consumerJob, bson.M{"$or": rs})
+		if err != nil {
+			return err
+		}
+		results[consumerJob] = make(map[int]string)
+		for _, v := range builds {
+			results[consumerJob][v.Buildnumber] = v.Result
+		}
+	}
+	for _, f := range fingerprints {
+		for _, u := range f.Usages {
+			for _, r := range u.Ranges {
+				for n := r.Start; n <= r.End; n++ {
+					if u.Jobname == jobName && n == buildNumber {
+						continue
+					}
+					result, ok := results[u.Jobname][n]
+					if !ok {
+						result = "-"
+					}
+					fmt.Println(strings.Join([]string{f.Filename, f.Md5, u.Jobname, strconv.Itoa(n), result}, ","))
+				}
+			}
+		}
+	}
+	return nil
+}
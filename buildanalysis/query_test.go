@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/manifest"
+	"github.com/all4dich/golang/buildanalysis/sink"
+)
+
+func ExampleDiffPackages() {
+	store := sink.NewMemorySink()
+	jobName := "starfish-drd4tv-official-h15"
+	store.UpsertBuild(sink.Record{Build: builddata.BuildData{Jobname: jobName, Buildnumber: 296, Manifests: []builddata.ManifestData{
+		{Type: manifest.TypeImage, Packages: []builddata.PackageData{
+			{Name: "busybox", Arch: "cortexa9", Version: "1.29.2"},
+			{Name: "glib-2.0", Arch: "cortexa9", Version: "2.56.1"},
+		}},
+		// Packages of several images are merged by names and architectures
+		{Type: manifest.TypeImage, Packages: []builddata.PackageData{
+			{Name: "busybox", Arch: "cortexa9", Version: "1.29.2"},
+			{Name: "luna-service2", Arch: "cortexa9", Version: "3.21.2"},
+		}},
+		// License manifests are ignored if a build has an image manifest
+		{Type: manifest.TypeLicense, Packages: []builddata.PackageData{
+			{Name: "openssl", Arch: "cortexa9", Version: "1.1.1"},
+		}},
+	}}})
+	store.UpsertBuild(sink.Record{Build: builddata.BuildData{Jobname: jobName, Buildnumber: 297, Manifests: []builddata.ManifestData{
+		{Type: manifest.TypeImage, Packages: []builddata.PackageData{
+			{Name: "busybox", Arch: "cortexa9", Version: "1.29.3"},
+			{Name: "glib-2.0", Arch: "cortexa9", Version: "2.56.1"},
+			{Name: "glib-2.0", Arch: "x86_64", Version: "2.56.1"},
+		}},
+	}}})
+	store.UpsertBuild(sink.Record{Build: builddata.BuildData{Jobname: jobName, Buildnumber: 298}})
+
+	fmt.Println(DiffPackages(store, jobName, "296:297"))
+	fmt.Println(DiffPackages(store, jobName, "297:298"))
+	fmt.Println(DiffPackages(store, jobName, "297:299"))
+	fmt.Println(DiffPackages(store, jobName, "297"))
+	// Output:
+	// busybox,cortexa9,1.29.2,1.29.3
+	// glib-2.0,x86_64,-,2.56.1
+	// luna-service2,cortexa9,3.21.2,-
+	// <nil>
+	// Build starfish-drd4tv-official-h15 #298 doesn't have any manifest
+	// Cannot find build starfish-drd4tv-official-h15 #299: not found
+	// Invalid build range: 297
+}
+
+func ExampleConsumers() {
+	store := sink.NewMemorySink()
+	store.UpsertFingerprint(builddata.FingerprintData{
+		Md5:      "5d41402abc4b2a76b9719d911017c592",
+		Filename: "webos-image-h15.tar.gz",
+		Original: builddata.FingerprintBuild{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297},
+		Usages: []builddata.FingerprintUsage{
+			// A producer is also a user of its own files, and isn't printed
+			{Jobname: "starfish-drd4tv-official-h15", Ranges: []builddata.FingerprintRange{{Start: 297, End: 297}}},
+			{Jobname: "starfish-drd4tv-verify-h15", Ranges: []builddata.FingerprintRange{{Start: 5, End: 7}, {Start: 9, End: 9}}},
+		},
+	})
+	for n, result := range map[int]string{4: "SUCCESS", 5: "SUCCESS", 6: "FAILURE", 8: "SUCCESS", 9: "ABORTED"} {
+		store.UpsertBuild(sink.Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-verify-h15", Buildnumber: n, Result: result}})
+	}
+	// Results are read with a query of ranges of each job, and a build that isn't stored has '-'
+	fmt.Println(Consumers(store, "starfish-drd4tv-official-h15", 297))
+	fmt.Println(Consumers(store, "starfish-drd4tv-official-h15", 298))
+	// Output:
+	// webos-image-h15.tar.gz,5d41402abc4b2a76b9719d911017c592,starfish-drd4tv-verify-h15,5,SUCCESS
+	// webos-image-h15.tar.gz,5d41402abc4b2a76b9719d911017c592,starfish-drd4tv-verify-h15,6,FAILURE
+	// webos-image-h15.tar.gz,5d41402abc4b2a76b9719d911017c592,starfish-drd4tv-verify-h15,7,-
+	// webos-image-h15.tar.gz,5d41402abc4b2a76b9719d911017c592,starfish-drd4tv-verify-h15,9,ABORTED
+	// <nil>
+	// Build starfish-drd4tv-official-h15 #298 doesn't have any fingerprint
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ReconcileReport is a difference between build directories and a database
+type ReconcileReport struct {
+	// MissingOnDisk: stored builds whose directories have been deleted, e.g. by log rotation
+	// MissingInDB: finished builds that haven't been stored
+	// Mismatched: stored builds whose build.xml has been changed or that were stored while running
+	MissingOnDisk []builddata.BuildData
+	MissingInDB   []string
+	Mismatched    []string
+}
+
+// Reconcile compares builds of jobs that match 'pattern' on 'jobsDir' with stored records
+func Reconcile(jobsDir string, pattern string, store sink.Store) (ReconcileReport, error) {
+	report := ReconcileReport{}
+	jobs := make(map[string]bool)
+	dirs, err := filepath.Glob(jobsDir + "/" + pattern)
+	if err != nil {
+		return report, err
+	}
+	for _, d := range dirs {
+		if fi, err := os.Stat(d + "/builds"); err == nil && fi.IsDir() {
+			jobs[filepath.Base(d)] = true
+		}
+	}
+	// Jobs that have been deleted entirely exist only on a database
+	storedJobs, err := store.JobNames()
+	if err != nil {
+		return report, err
+	}
+	for _, j := range storedJobs {
+		if m, _ := filepath.Match(pattern, j); m {
+			jobs[j] = true
+		}
+	}
+	jobNames := make([]string, 0, len(jobs))
+	for j := range jobs {
+		jobNames = append(jobNames, j)
+	}
+	sort.Strings(jobNames)
+
+	for _, jobName := range jobNames {
+		buildsDir := jobsDir + "/" + jobName + "/builds"
+		onDisk := make(map[int]string)
+		builds, _ := ioutil.ReadDir(buildsDir)
+		for _, build := range builds {
+			if build.IsDir() && validBuildNumber.MatchString(build.Name()) {
+				n, _ := strconv.Atoi(build.Name())
+				onDisk[n] = buildsDir + "/" + build.Name()
+			}
+		}
+		stored, err := store.StoredBuilds(jobName)
+		if err != nil {
+			return report, err
+		}
+		isStored := make(map[int]bool)
+		for _, v := range stored {
+			isStored[v.Buildnumber] = true
+			buildDir, ok := onDisk[v.Buildnumber]
+			if !ok {
+				report.MissingOnDisk = append(report.MissingOnDisk, v)
+				continue
+			}
+			isProvisional := v.Provisional || v.Result == ""
+			if IsBuildChanged(buildDir, v) || (isProvisional && IsBuildFinished(buildDir)) {
+				report.Mismatched = append(report.Mismatched, buildDir)
+			}
+		}
+		numbers := make([]int, 0, len(onDisk))
+		for n := range onDisk {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		for _, n := range numbers {
+			buildDir := onDisk[n]
+			if isStored[n] {
+				continue
+			}
+			if _, err := os.Stat(buildDir + "/log"); err != nil {
+				continue
+			}
+			if IsBuildFinished(buildDir) {
+				report.MissingInDB = append(report.MissingInDB, buildDir)
+			}
+		}
+	}
+	return report, nil
+}
+
+// Print writes a report as lines of a type, a job name and a build number
+func (r ReconcileReport) Print() {
+	for _, v := range r.MissingOnDisk {
+		fmt.Printf("missing-on-disk,%s,%d\n", v.Jobname, v.Buildnumber)
+	}
+	for _, buildDir := range r.MissingInDB {
+		fmt.Printf("missing-in-db,%s,%s\n", filepath.Base(filepath.Dir(filepath.Dir(buildDir))), filepath.Base(buildDir))
+	}
+	for _, buildDir := range r.Mismatched {
+		fmt.Printf("mismatched,%s,%s\n", filepath.Base(filepath.Dir(filepath.Dir(buildDir))), filepath.Base(buildDir))
+	}
+	log.Printf("INFO: Missing on disk = %d, Missing in DB = %d, Mismatched = %d", len(r.MissingOnDisk), len(r.MissingInDB), len(r.Mismatched))
+}
+
+// MarkDeleted sets 'source_deleted' on records whose build directories have been deleted
+func (r ReconcileReport) MarkDeleted(store sink.Store) error {
+	for _, v := range r.MissingOnDisk {
+		if v.Source_deleted {
+			continue
+		}
+		if err := store.MarkDeleted(v.Jobname, v.Buildnumber); err != nil {
+			return err
+		}
+	}
+	return nil
+}
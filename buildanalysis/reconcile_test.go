@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func ExampleReconcile() {
+	store := sink.NewMemorySink()
+	for _, v := range []builddata.BuildData{
+		// build.xml has been changed since it was stored
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 1, Result: "SUCCESS", Source_mtime: 1, Source_hash: "0000"},
+		// Stored while it was running
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 2, Provisional: true},
+		// Deleted by log rotation
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 3, Result: "SUCCESS"},
+		// A job that has been deleted entirely
+		{Jobname: "starfish-m16-official-h15", Buildnumber: 7, Result: "FAILURE"},
+	} {
+		store.UpsertBuild(sink.Record{Build: v})
+	}
+	// A job name without a machine is compared too
+	report, err := Reconcile(samplesJobsDir, "*", store)
+	fmt.Println(err)
+	report.Print()
+	fmt.Println(report.MarkDeleted(store))
+	for _, jobName := range []string{"starfish-drd4tv-official-h15", "starfish-m16-official-h15"} {
+		builds, _ := store.FindBuilds(jobName, bson.M{"source_deleted": true})
+		for _, v := range builds {
+			fmt.Println("deleted", v.Jobname, v.Buildnumber)
+		}
+	}
+
+	// A build stored with its source is up to date
+	mtime, hash := BuildSource(samplesJobsDir + "/tools/builds/1")
+	store.UpsertBuild(sink.Record{Build: builddata.BuildData{Jobname: "tools", Buildnumber: 1, Result: "SUCCESS", Source_mtime: mtime, Source_hash: hash}})
+	report, _ = Reconcile(samplesJobsDir, "tools", store)
+	fmt.Println(len(report.MissingOnDisk), len(report.MissingInDB), len(report.Mismatched))
+	// Output:
+	// <nil>
+	// missing-on-disk,starfish-drd4tv-official-h15,3
+	// missing-on-disk,starfish-m16-official-h15,7
+	// missing-in-db,tools,1
+	// mismatched,starfish-drd4tv-official-h15,1
+	// mismatched,starfish-drd4tv-official-h15,2
+	// <nil>
+	// deleted starfish-drd4tv-official-h15 3
+	// deleted starfish-m16-official-h15 7
+	// 0 0 0
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ParserVersion is stored on each record as 'parser_version'.
+// Increase it whenever AnalyzeBuild or NewBuildData changes values that are stored,
+// so records by an older parser can be analyzed again with '-reprocess'
+//
+// 1: build.xml and log
+// 2: test results, artifacts, manifests, environment variables and source checksums
+// 3: layers and failures
+// 4: sstate summary
+const ParserVersion = 4
+
+// ReprocessTargets returns build numbers of a job to analyze again.
+// They are builds stored by an older parser, or builds that match 'filter'
+// if it's given as a JSON query like '{"result": "FAILURE"}'
+func ReprocessTargets(store sink.Store, jobName string, filter string) ([]int, error) {
+	var builds []builddata.BuildData
+	var err error
+	if filter != "" {
+		query := bson.M{}
+		if err := bson.UnmarshalJSON([]byte(filter), &query); err != nil {
+			return nil, err
+		}
+		builds, err = store.FindBuilds(jobName, query)
+	} else {
+		builds, err = store.StoredBuilds(jobName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	targets := []int{}
+	for _, v := range builds {
+		// Records before 'parser_version' was introduced have 0
+		if filter != "" || v.Parser_version < ParserVersion {
+			targets = append(targets, v.Buildnumber)
+		}
+	}
+	return targets, nil
+}
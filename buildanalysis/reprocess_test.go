@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/sink"
+)
+
+func ExampleReprocessTargets() {
+	store := sink.NewMemorySink()
+	jobName := "starfish-drd4tv-official-h15"
+	for _, v := range []builddata.BuildData{
+		// A record before 'parser_version' was introduced
+		{Buildnumber: 295, Result: "SUCCESS"},
+		{Buildnumber: 296, Result: "FAILURE", Parser_version: ParserVersion - 1},
+		{Buildnumber: 297, Result: "FAILURE", Parser_version: ParserVersion},
+		{Buildnumber: 298, Result: "SUCCESS", Parser_version: ParserVersion},
+	} {
+		v.Jobname = jobName
+		store.UpsertBuild(sink.Record{Build: v})
+	}
+	// Builds stored by an older parser
+	fmt.Println(ReprocessTargets(store, jobName, ""))
+	// Builds that match a filter, whatever parsers stored them
+	fmt.Println(ReprocessTargets(store, jobName, `{"result": "FAILURE"}`))
+	fmt.Println(ReprocessTargets(store, jobName, `{"result": "FAILURE", "parser_version": {"$gte": 4}}`))
+	_, err := ReprocessTargets(store, jobName, `{"result": `)
+	fmt.Println(err != nil)
+	// Output:
+	// [295 296] <nil>
+	// [296 297] <nil>
+	// [297] <nil>
+	// true
+}
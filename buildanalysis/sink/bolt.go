@@ -0,0 +1,429 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	boltBuilds       = []byte("builds")
+	boltTestCases    = []byte("testcases")
+	boltFingerprints = []byte("fingerprints")
+	boltJobs         = []byte("jobs")
+	boltErrors       = []byte("ingest_errors")
+)
+
+// BoltSink stores builds on a single file, so it needs no DB server, e.g. on a laptop or a CI job.
+// Records are BSON documents same as MongoDB, keyed by a job name and a build number.
+// Builds of an 'Instance' are kept on their own buckets
+type BoltSink struct {
+	Path     string
+	Instance string
+
+	db *bbolt.DB
+}
+
+// boltKey sorts builds of a job by build numbers
+func boltKey(jobName string, buildNumber int) []byte {
+	return []byte(fmt.Sprintf("%s\x00%010d", jobName, buildNumber))
+}
+
+func boltPrefix(jobName string) []byte {
+	return []byte(jobName + "\x00")
+}
+
+// buildsBucket and testCasesBucket return bucket names for an instance
+func (b *BoltSink) buildsBucket() []byte {
+	if b.Instance == "" {
+		return boltBuilds
+	}
+	return []byte(string(boltBuilds) + "@" + b.Instance)
+}
+
+func (b *BoltSink) testCasesBucket() []byte {
+	if b.Instance == "" {
+		return boltTestCases
+	}
+	return []byte(string(boltTestCases) + "@" + b.Instance)
+}
+
+func (b *BoltSink) Open() error {
+	if dir := filepath.Dir(b.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	// Another process holding the file makes Open fail instead of waiting forever
+	db, err := bbolt.Open(b.Path, 0644, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{b.buildsBucket(), b.testCasesBucket(), boltFingerprints, boltJobs, boltErrors} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	b.db = db
+	return nil
+}
+
+// UpsertBuild replaces a build and, if it has a test result, its test cases
+func (b *BoltSink) UpsertBuild(r Record) error {
+	doc, err := bson.Marshal(&r.Build)
+	if err != nil {
+		return err
+	}
+	k := boltKey(r.Build.Jobname, r.Build.Buildnumber)
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if r.HasTestResult {
+			cases, err := bson.Marshal(bson.M{"testcases": r.TestCases})
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(b.testCasesBucket()).Put(k, cases); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(b.buildsBucket()).Put(k, doc)
+	})
+}
+
+// Flush does nothing because every build is committed by UpsertBuild
+func (b *BoltSink) Flush() error {
+	return nil
+}
+
+func (b *BoltSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	return b.FindBuilds(jobName, bson.M{})
+}
+
+func (b *BoltSink) Build(jobName string, buildNumber int) (builddata.BuildData, error) {
+	v := builddata.BuildData{}
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		doc := tx.Bucket(b.buildsBucket()).Get(boltKey(jobName, buildNumber))
+		if doc == nil {
+			return ErrNotFound
+		}
+		return bson.Unmarshal(doc, &v)
+	})
+	return v, err
+}
+
+// FindBuilds returns whole builds of a job that match 'filter', sorted by build numbers.
+// See Match for supported queries
+func (b *BoltSink) FindBuilds(jobName string, filter bson.M) ([]builddata.BuildData, error) {
+	builds := []builddata.BuildData{}
+	prefix := boltPrefix(jobName)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(b.buildsBucket()).Cursor()
+		for k, doc := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, doc = c.Next() {
+			if len(filter) > 0 {
+				m := bson.M{}
+				if err := bson.Unmarshal(doc, &m); err != nil {
+					return err
+				}
+				if !Match(m, filter) {
+					continue
+				}
+			}
+			v := builddata.BuildData{}
+			if err := bson.Unmarshal(doc, &v); err != nil {
+				return err
+			}
+			builds = append(builds, v)
+		}
+		return nil
+	})
+	return builds, err
+}
+
+func (b *BoltSink) JobNames() ([]string, error) {
+	jobNames := []string{}
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(b.buildsBucket()).Cursor()
+		for k, _ := c.First(); k != nil; {
+			jobName := string(k[:bytes.IndexByte(k, 0)])
+			jobNames = append(jobNames, jobName)
+			// Skip the other builds of the job
+			k, _ = c.Seek([]byte(jobName + "\x01"))
+		}
+		return nil
+	})
+	return jobNames, err
+}
+
+func (b *BoltSink) MarkDeleted(jobName string, buildNumber int) error {
+	k := boltKey(jobName, buildNumber)
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(b.buildsBucket())
+		doc := bucket.Get(k)
+		if doc == nil {
+			return ErrNotFound
+		}
+		v := builddata.BuildData{}
+		if err := bson.Unmarshal(doc, &v); err != nil {
+			return err
+		}
+		v.Source_deleted = true
+		doc, err := bson.Marshal(&v)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(k, doc)
+	})
+}
+
+// UpsertFingerprint stores a fingerprint keyed by an MD5 checksum
+func (b *BoltSink) UpsertFingerprint(f builddata.FingerprintData) error {
+	doc, err := bson.Marshal(&f)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltFingerprints).Put([]byte(f.Md5), doc)
+	})
+}
+
+// FingerprintsOf scans every fingerprint because there are no secondary indexes
+func (b *BoltSink) FingerprintsOf(jobName string, buildNumber int) ([]builddata.FingerprintData, error) {
+	fingerprints := []builddata.FingerprintData{}
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltFingerprints).ForEach(func(k, doc []byte) error {
+			f := builddata.FingerprintData{}
+			if err := bson.Unmarshal(doc, &f); err != nil {
+				return err
+			}
+			if f.Original.Jobname == jobName && f.Original.Buildnumber == buildNumber {
+				fingerprints = append(fingerprints, f)
+			}
+			return nil
+		})
+	})
+	sort.Slice(fingerprints, func(i, j int) bool {
+		return fingerprints[i].Filename < fingerprints[j].Filename
+	})
+	return fingerprints, err
+}
+
+// UpsertJob stores a job state keyed by a job name
+func (b *BoltSink) UpsertJob(j builddata.JobData) error {
+	doc, err := bson.Marshal(&j)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltJobs).Put([]byte(j.Jobname), doc)
+	})
+}
+
+func (b *BoltSink) Job(jobName string) (builddata.JobData, error) {
+	j := builddata.JobData{}
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		doc := tx.Bucket(boltJobs).Get([]byte(jobName))
+		if doc == nil {
+			return ErrNotFound
+		}
+		return bson.Unmarshal(doc, &j)
+	})
+	return j, err
+}
+
+// RecordError stores a build that couldn't be ingested, keyed by an instance and a build directory
+func (b *BoltSink) RecordError(e builddata.IngestErrorData) error {
+	e.Instance = b.Instance
+	doc, err := bson.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltErrors).Put([]byte(e.Instance+"\x00"+e.Path), doc)
+	})
+}
+
+// ClearErrors removes errors of build directories of an instance
+func (b *BoltSink) ClearErrors(paths []string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltErrors)
+		for _, path := range paths {
+			if err := bucket.Delete([]byte(b.Instance + "\x00" + path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltSink) Close() error {
+	if b.db != nil {
+		return b.db.Close()
+	}
+	return nil
+}
+
+// lookup returns a value on a document by a dotted path like 'original.jobname'
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// toFloat converts numbers of BSON and JSON to float64 to compare them
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func equal(a interface{}, b interface{}) bool {
+	x, ok1 := toFloat(a)
+	y, ok2 := toFloat(b)
+	if ok1 && ok2 {
+		return x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// normalize converts documents of a filter to bson.M and lists to []interface{} at every level.
+// Filters that are decoded by bson.UnmarshalJSON have map[string]interface{} inside
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := bson.M{}
+		for k, e := range x {
+			m[k] = normalize(e)
+		}
+		return m
+	case bson.M:
+		m := bson.M{}
+		for k, e := range x {
+			m[k] = normalize(e)
+		}
+		return m
+	case []bson.M:
+		l := []interface{}{}
+		for _, e := range x {
+			l = append(l, normalize(e))
+		}
+		return l
+	case []interface{}:
+		l := []interface{}{}
+		for _, e := range x {
+			l = append(l, normalize(e))
+		}
+		return l
+	}
+	return v
+}
+
+// Match checks if a document matches a subset of MongoDB queries:
+// equality, '$or', '$and', and '$ne', '$in', '$exists', '$lt', '$lte', '$gt', '$gte' on a field
+func Match(doc bson.M, filter bson.M) bool {
+	for k, cond := range filter {
+		cond = normalize(cond)
+		switch k {
+		case "$or", "$and":
+			subs, ok := cond.([]interface{})
+			if !ok {
+				return false
+			}
+			matched := k == "$and"
+			for _, s := range subs {
+				// A condition that isn't a document matches nothing
+				m, ok := s.(bson.M)
+				if (ok && Match(doc, m)) != (k == "$and") {
+					matched = k != "$and"
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+			continue
+		}
+		v, exists := lookup(doc, k)
+		ops, isOps := cond.(bson.M)
+		if !isOps {
+			if !exists || !equal(v, cond) {
+				return false
+			}
+			continue
+		}
+		for op, arg := range ops {
+			x, ok1 := toFloat(v)
+			y, ok2 := toFloat(arg)
+			isNumber := exists && ok1 && ok2
+			switch op {
+			case "$exists":
+				if exists != (arg == true) {
+					return false
+				}
+			case "$ne":
+				if exists && equal(v, arg) {
+					return false
+				}
+			case "$in":
+				args, _ := arg.([]interface{})
+				found := false
+				for _, a := range args {
+					if exists && equal(v, a) {
+						found = true
+					}
+				}
+				if !found {
+					return false
+				}
+			case "$lt":
+				if !isNumber || !(x < y) {
+					return false
+				}
+			case "$lte":
+				if !isNumber || !(x <= y) {
+					return false
+				}
+			case "$gt":
+				if !isNumber || !(x > y) {
+					return false
+				}
+			case "$gte":
+				if !isNumber || !(x >= y) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
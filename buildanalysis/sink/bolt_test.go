@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func ExampleBoltSink() {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	var s Store = &BoltSink{Path: filepath.Join(dir, "builds.db")}
+	if err := s.Open(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer s.Close()
+	s.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 1000, Result: "SUCCESS", Parser_version: 3}})
+	s.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297, Result: "FAILURE"}})
+	s.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297, Result: "SUCCESS"}})
+	s.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-m16-official-h15", Buildnumber: 1, Result: "ABORTED"}})
+	s.MarkDeleted("starfish-drd4tv-official-h15", 297)
+
+	builds, _ := s.StoredBuilds("starfish-drd4tv-official-h15")
+	for _, v := range builds {
+		fmt.Println(v.Buildnumber, v.Result, v.Source_deleted)
+	}
+	builds, _ = s.FindBuilds("starfish-drd4tv-official-h15", bson.M{"parser_version": bson.M{"$lt": 3}})
+	fmt.Println(len(builds), builds[0].Buildnumber)
+	fmt.Println(s.JobNames())
+	_, err = s.Build("starfish-drd4tv-official-h15", 298)
+	fmt.Println(err)
+	// Output:
+	// 297 SUCCESS true
+	// 1000 SUCCESS false
+	// 1 297
+	// [starfish-drd4tv-official-h15 starfish-m16-official-h15] <nil>
+	// not found
+}
+
+func ExampleBoltSink_RecordError() {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	b := &BoltSink{Path: filepath.Join(dir, "builds.db")}
+	if err := b.Open(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer b.Close()
+	b.RecordError(builddata.IngestErrorData{Path: "/jenkins/jobs/starfish-drd4tv-official-h15/builds/296", Stage: "parse"})
+	b.RecordError(builddata.IngestErrorData{Path: "/jenkins/jobs/starfish-drd4tv-official-h15/builds/297", Stage: "read"})
+	b.RecordError(builddata.IngestErrorData{Path: "/jenkins/jobs/starfish-drd4tv-official-h15/builds/297", Stage: "store"})
+	// An error is removed once its build has been written
+	b.ClearErrors([]string{"/jenkins/jobs/starfish-drd4tv-official-h15/builds/296"})
+	b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltErrors).ForEach(func(k, v []byte) error {
+			e := builddata.IngestErrorData{}
+			bson.Unmarshal(v, &e)
+			fmt.Println(e.Path, e.Stage)
+			return nil
+		})
+	})
+	// Output:
+	// /jenkins/jobs/starfish-drd4tv-official-h15/builds/297 store
+}
+
+func ExampleMatch() {
+	doc := bson.M{"result": "FAILURE", "buildnumber": 297, "original": bson.M{"jobname": "starfish-drd4tv-official-h15"}}
+	fmt.Println(Match(doc, bson.M{"result": "FAILURE", "original.jobname": "starfish-drd4tv-official-h15"}))
+	fmt.Println(Match(doc, bson.M{"buildnumber": bson.M{"$gte": 297.0, "$lt": 300}}))
+	fmt.Println(Match(doc, bson.M{"$or": []interface{}{bson.M{"result": "SUCCESS"}, bson.M{"parser_version": bson.M{"$exists": false}}}}))
+	fmt.Println(Match(doc, bson.M{"result": bson.M{"$in": []interface{}{"SUCCESS", "UNSTABLE"}}}))
+	// Output:
+	// true
+	// true
+	// true
+	// false
+}
+
+func ExampleMatch_json() {
+	doc := bson.M{"result": "FAILURE", "buildnumber": 297, "parser_version": 2}
+	// Filters of -reprocessFilter are decoded with map[string]interface{} inside
+	for _, s := range []string{
+		`{"parser_version": {"$lt": 3}}`,
+		`{"parser_version": {"$gte": 3}}`,
+		`{"$or": [{"result": "SUCCESS"}, {"buildnumber": {"$gt": 296}}]}`,
+		`{"$or": [{"result": "SUCCESS"}]}`,
+		`{"$and": [{"result": "FAILURE"}, {"buildnumber": {"$in": [296, 298]}}]}`,
+		`{"$or": ["result"]}`,
+	} {
+		filter := bson.M{}
+		if err := bson.UnmarshalJSON([]byte(s), &filter); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(Match(doc, filter))
+	}
+	// Output:
+	// true
+	// false
+	// true
+	// false
+	// false
+	// false
+}
@@ -0,0 +1,458 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultElasticBatchSize  = 500
+	defaultElasticMaxRetries = 5
+)
+
+// elasticTemplate maps fields of builds for Kibana. Times are dates, floats are kept as floats
+// even if a first value looks like an integer, and strings are keywords except free texts
+const elasticTemplate = `{
+	"index_patterns": ["%s-*"],
+	"template": {
+		"mappings": {
+			"dynamic_templates": [
+				{"times": {"match": "time_*", "mapping": {"type": "float"}}},
+				{"strings": {"match_mapping_type": "string", "mapping": {"type": "keyword", "ignore_above": 1024}}}
+			],
+			"properties": {
+				"@timestamp":        {"type": "date"},
+				"start":             {"type": "date", "format": "epoch_second"},
+				"source_mtime":      {"type": "date", "format": "epoch_second"},
+				"instance":          {"type": "keyword"},
+				"jobname":           {"type": "keyword"},
+				"buildnumber":       {"type": "integer"},
+				"result":            {"type": "keyword"},
+				"host":              {"type": "keyword"},
+				"machine":           {"type": "keyword"},
+				"duration":          {"type": "integer"},
+				"waiting":           {"type": "integer"},
+				"duration_in_queue": {"type": "float"},
+				"tests_duration":    {"type": "float"},
+				"artifacts_size":    {"type": "long"},
+				"description":       {"type": "text"},
+				"failures":          {"type": "text"},
+				"environment":       {"type": "object", "enabled": false},
+				"manifests":         {"type": "object", "enabled": false}
+			}
+		}
+	}
+}`
+
+// ElasticSink sends builds to Elasticsearch or OpenSearch with the '_bulk' API.
+// A build goes to an index of the month when it started, e.g. 'buildanalysis-2019.03',
+// and its document ID is an instance, a job name and a build number, so storing it again replaces it.
+// A build that has been stored without a start time is deleted from the undated index
+// once it's stored with one. Builds that couldn't be ingested are kept on '<Index>_ingest_errors',
+// which isn't one of indexes of builds
+type ElasticSink struct {
+	Url      string
+	Index    string
+	User     string
+	Pass     string
+	Instance string
+	// BatchSize is a number of builds sent with a request. 500 if it's 0
+	BatchSize int
+	// MaxRetries is how many times builds rejected with 429 are sent again. 5 if it's 0
+	MaxRetries int
+	// RetryWait is a wait before a first retry, and it doubles on every retry. 1 second if it's 0
+	RetryWait time.Duration
+	Client    *http.Client
+
+	mutex   sync.Mutex
+	pending []elasticDoc
+}
+
+type elasticDoc struct {
+	index string
+	id    string
+	body  []byte
+	// undated is an index to delete a document from, if the document has moved to a dated one
+	undated string
+	// record is returned with a *DroppedError if a document can't be indexed
+	record Record
+}
+
+// ElasticIndex returns an index name for a build by the month when it started
+func ElasticIndex(prefix string, b builddata.BuildData) string {
+	if b.Start == 0 {
+		return prefix + "-undated"
+	}
+	return prefix + "-" + time.Unix(int64(b.Start), 0).UTC().Format("2006.01")
+}
+
+func (e *ElasticSink) do(method string, path string, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(e.Url, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.User != "" {
+		req.SetBasicAuth(e.User, e.Pass)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	dat, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, dat, err
+}
+
+// Open installs an index template for indexes of builds
+func (e *ElasticSink) Open() error {
+	if e.Client == nil {
+		e.Client = &http.Client{Timeout: time.Minute}
+	}
+	if e.BatchSize == 0 {
+		e.BatchSize = defaultElasticBatchSize
+	}
+	if e.MaxRetries == 0 {
+		e.MaxRetries = defaultElasticMaxRetries
+	}
+	if e.RetryWait == 0 {
+		e.RetryWait = time.Second
+	}
+	status, dat, err := e.do("PUT", "/_index_template/"+e.Index, "application/json", []byte(fmt.Sprintf(elasticTemplate, e.Index)))
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("Cannot put an index template %s: %d %s", e.Index, status, dat)
+	}
+	return nil
+}
+
+// ElasticDocument returns a build as a JSON document with the same field names that it has on MongoDB,
+// and '@timestamp' for Kibana
+func ElasticDocument(b builddata.BuildData) ([]byte, error) {
+	raw, err := bson.Marshal(&b)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	// '_id' is a metadata field and can't be in a document
+	delete(doc, "_id")
+	if b.Start != 0 {
+		doc["@timestamp"] = time.Unix(int64(b.Start), 0).UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(doc)
+}
+
+// UpsertBuild queues a build and sends queued builds once there are 'BatchSize' of them.
+// Test cases aren't sent
+func (e *ElasticSink) UpsertBuild(r Record) error {
+	r.Build.Instance = e.Instance
+	body, err := ElasticDocument(r.Build)
+	if err != nil {
+		return err
+	}
+	id := r.Build.Jobname + "#" + strconv.Itoa(r.Build.Buildnumber)
+	if e.Instance != "" {
+		id = e.Instance + "#" + id
+	}
+	doc := elasticDoc{
+		index:  ElasticIndex(e.Index, r.Build),
+		id:     id,
+		body:   body,
+		record: Record{Build: r.Build, Path: r.Path},
+	}
+	if r.Build.Start != 0 {
+		doc.undated = ElasticIndex(e.Index, builddata.BuildData{})
+	}
+	e.mutex.Lock()
+	e.pending = append(e.pending, doc)
+	if len(e.pending) < e.BatchSize {
+		e.mutex.Unlock()
+		return nil
+	}
+	docs := e.pending
+	e.pending = nil
+	e.mutex.Unlock()
+	return e.bulk(docs)
+}
+
+// Flush sends queued builds
+func (e *ElasticSink) Flush() error {
+	e.mutex.Lock()
+	docs := e.pending
+	e.pending = nil
+	e.mutex.Unlock()
+	if len(docs) == 0 {
+		return nil
+	}
+	return e.bulk(docs)
+}
+
+type elasticBulkResponse struct {
+	Errors bool
+	Items  []map[string]struct {
+		Status int
+		Error  json.RawMessage
+	}
+}
+
+// droppedDocs returns a *DroppedError of documents that couldn't be indexed
+func droppedDocs(docs []elasticDoc, err error) error {
+	records := make([]Record, 0, len(docs))
+	for _, d := range docs {
+		records = append(records, d.record)
+	}
+	return &DroppedError{Records: records, Err: err}
+}
+
+// bulk sends documents, and sends them again with a backoff while some of them are rejected with 429.
+// A document that isn't on the undated index isn't an error of deleting it.
+// Documents that fail or are still rejected after retries are returned with a *DroppedError
+func (e *ElasticSink) bulk(docs []elasticDoc) error {
+	wait := e.RetryWait
+	for retry := 0; ; retry++ {
+		var buf bytes.Buffer
+		// actions has an index of a document for each item of a response
+		actions := []int{}
+		for i, d := range docs {
+			action, _ := json.Marshal(bson.M{"index": bson.M{"_index": d.index, "_id": d.id}})
+			buf.Write(action)
+			buf.WriteByte('\n')
+			buf.Write(d.body)
+			buf.WriteByte('\n')
+			actions = append(actions, i)
+			if d.undated != "" {
+				action, _ := json.Marshal(bson.M{"delete": bson.M{"_index": d.undated, "_id": d.id}})
+				buf.Write(action)
+				buf.WriteByte('\n')
+				actions = append(actions, i)
+			}
+		}
+		status, dat, err := e.do("POST", "/_bulk", "application/x-ndjson", buf.Bytes())
+		if err != nil {
+			return droppedDocs(docs, err)
+		}
+		rejected := []elasticDoc{}
+		if status == http.StatusTooManyRequests {
+			rejected = docs
+		} else if status >= 300 {
+			return droppedDocs(docs, fmt.Errorf("Bulk request failed: %d %s", status, dat))
+		} else {
+			resp := elasticBulkResponse{}
+			if err := json.Unmarshal(dat, &resp); err != nil {
+				return droppedDocs(docs, err)
+			}
+			failed := []elasticDoc{}
+			var firstError json.RawMessage
+			isRejected := make(map[int]bool)
+			isFailed := make(map[int]bool)
+			for i, item := range resp.Items {
+				if i >= len(actions) {
+					break
+				}
+				for action, result := range item {
+					if result.Status == http.StatusTooManyRequests {
+						if !isRejected[actions[i]] {
+							isRejected[actions[i]] = true
+							rejected = append(rejected, docs[actions[i]])
+						}
+					} else if action == "delete" && result.Status == http.StatusNotFound {
+						continue
+					} else if result.Status >= 300 {
+						if !isFailed[actions[i]] {
+							isFailed[actions[i]] = true
+							failed = append(failed, docs[actions[i]])
+						}
+						if firstError == nil {
+							firstError = result.Error
+						}
+					}
+				}
+			}
+			if len(failed) > 0 {
+				// Rejected documents are given up with failed ones, and the others have been indexed
+				for i, d := range docs {
+					if isRejected[i] && !isFailed[i] {
+						failed = append(failed, d)
+					}
+				}
+				return droppedDocs(failed, fmt.Errorf("Cannot index %d builds: %s", len(failed), firstError))
+			}
+		}
+		if len(rejected) == 0 {
+			return nil
+		}
+		if retry >= e.MaxRetries {
+			return droppedDocs(rejected, fmt.Errorf("Cannot index %d builds: too many requests", len(rejected)))
+		}
+		time.Sleep(wait)
+		wait *= 2
+		docs = rejected
+	}
+}
+
+func (e *ElasticSink) errorIndex() string {
+	return e.Index + "_ingest_errors"
+}
+
+// errorID is an ID of an error of a build directory. A path can be longer than an ID can be
+func (e *ElasticSink) errorID(path string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(e.Instance+"\x00"+path)))
+}
+
+// RecordError stores a build that couldn't be ingested, keyed by an instance and a build directory
+func (e *ElasticSink) RecordError(ie builddata.IngestErrorData) error {
+	body, err := json.Marshal(bson.M{
+		"instance":    e.Instance,
+		"jobname":     ie.Jobname,
+		"buildnumber": ie.Buildnumber,
+		"path":        ie.Path,
+		"stage":       ie.Stage,
+		"message":     ie.Message,
+		"@timestamp":  time.Unix(int64(ie.Time), 0).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	status, dat, err := e.do("PUT", "/"+e.errorIndex()+"/_doc/"+e.errorID(ie.Path), "application/json", body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("Cannot record an error: %d %s", status, dat)
+	}
+	return nil
+}
+
+// ClearErrors removes errors of build directories of an instance
+func (e *ElasticSink) ClearErrors(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, path := range paths {
+		action, _ := json.Marshal(bson.M{"delete": bson.M{"_index": e.errorIndex(), "_id": e.errorID(path)}})
+		buf.Write(action)
+		buf.WriteByte('\n')
+	}
+	status, dat, err := e.do("POST", "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("Cannot clear errors: %d %s", status, dat)
+	}
+	resp := elasticBulkResponse{}
+	if err := json.Unmarshal(dat, &resp); err != nil {
+		return err
+	}
+	for _, item := range resp.Items {
+		for _, result := range item {
+			// A build that never failed has no error to remove
+			if result.Status >= 300 && result.Status != http.StatusNotFound {
+				return fmt.Errorf("Cannot clear errors: %d %s", result.Status, result.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// instanceFilter matches builds of an instance. Builds stored before 'instance' was introduced
+// don't have it, and they are builds of an empty instance like on other sinks
+func (e *ElasticSink) instanceFilter() bson.M {
+	if e.Instance != "" {
+		return bson.M{"term": bson.M{"instance": e.Instance}}
+	}
+	return bson.M{"bool": bson.M{
+		"should": []bson.M{
+			{"term": bson.M{"instance": ""}},
+			{"bool": bson.M{"must_not": bson.M{"exists": bson.M{"field": "instance"}}}},
+		},
+		"minimum_should_match": 1,
+	}}
+}
+
+// StoredBuilds searches builds of a job of an instance on every index of builds
+func (e *ElasticSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	builds := []builddata.BuildData{}
+	filter := []bson.M{{"term": bson.M{"jobname": jobName}}, e.instanceFilter()}
+	var after []interface{}
+	for {
+		query := bson.M{
+			"size":    1000,
+			"query":   bson.M{"bool": bson.M{"filter": filter}},
+			"sort":    []bson.M{{"buildnumber": "asc"}},
+			"_source": []string{"jobname", "buildnumber", "result", "provisional", "parser_version", "start", "duration", "source_mtime", "source_hash", "source_deleted"},
+		}
+		if after != nil {
+			query["search_after"] = after
+		}
+		body, _ := json.Marshal(query)
+		status, dat, err := e.do("POST", "/"+e.Index+"-*/_search?ignore_unavailable=true&allow_no_indices=true", "application/json", body)
+		if err != nil {
+			return builds, err
+		}
+		if status >= 300 {
+			return builds, fmt.Errorf("Search failed: %d %s", status, dat)
+		}
+		resp := struct {
+			Hits struct {
+				Hits []struct {
+					Source struct {
+						Jobname        string
+						Buildnumber    int
+						Result         string
+						Provisional    bool
+						Parser_version int
+						Start          int
+						Duration       int
+						Source_mtime   int64
+						Source_hash    string
+						Source_deleted bool
+					} `json:"_source"`
+					Sort []interface{}
+				}
+			}
+		}{}
+		if err := json.Unmarshal(dat, &resp); err != nil {
+			return builds, err
+		}
+		for _, h := range resp.Hits.Hits {
+			s := h.Source
+			builds = append(builds, builddata.BuildData{
+				Jobname:        s.Jobname,
+				Buildnumber:    s.Buildnumber,
+				Result:         s.Result,
+				Provisional:    s.Provisional,
+				Parser_version: s.Parser_version,
+				Start:          s.Start,
+				Duration:       s.Duration,
+				Source_mtime:   s.Source_mtime,
+				Source_hash:    s.Source_hash,
+				Source_deleted: s.Source_deleted,
+			})
+			after = h.Sort
+		}
+		if len(resp.Hits.Hits) < 1000 {
+			return builds, nil
+		}
+	}
+}
+
+func (e *ElasticSink) Close() error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func ExampleElasticIndex() {
+	fmt.Println(ElasticIndex("buildanalysis", builddata.BuildData{Start: 1551657600}))
+	fmt.Println(ElasticIndex("buildanalysis", builddata.BuildData{}))
+	// Output:
+	// buildanalysis-2019.03
+	// buildanalysis-undated
+}
+
+// TestElasticSink sends builds to a stand-in server that rejects a whole request once
+// and then one document with 429, and checks they are all indexed once
+func TestElasticSink(t *testing.T) {
+	var mutex sync.Mutex
+	indexed := map[string]string{}
+	template := ""
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/_index_template/buildanalysis":
+			template = r.URL.Path
+			fmt.Fprint(w, `{"acknowledged": true}`)
+		case r.Method == "POST" && r.URL.Path == "/_bulk":
+			requests++
+			if requests == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			type item struct {
+				Status int `json:"status"`
+			}
+			items := []map[string]item{}
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				action := map[string]map[string]string{}
+				json.Unmarshal(scanner.Bytes(), &action)
+				if d, ok := action["delete"]; ok {
+					status := 404
+					if _, ok := indexed[d["_index"]+"/"+d["_id"]]; ok {
+						delete(indexed, d["_index"]+"/"+d["_id"])
+						status = 200
+					}
+					items = append(items, map[string]item{"delete": {Status: status}})
+					continue
+				}
+				scanner.Scan()
+				doc := map[string]interface{}{}
+				json.Unmarshal(scanner.Bytes(), &doc)
+				status := 201
+				if requests == 2 && len(items) == 0 {
+					status = 429
+				} else {
+					indexed[action["index"]["_index"]+"/"+action["index"]["_id"]] = fmt.Sprint(doc["result"], " ", doc["@timestamp"])
+				}
+				items = append(items, map[string]item{"index": {Status: status}})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e := &ElasticSink{Url: server.URL, Index: "buildanalysis", BatchSize: 2, RetryWait: time.Millisecond}
+	if err := e.Open(); err != nil {
+		t.Fatal(err)
+	}
+	builds := []builddata.BuildData{
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 296, Result: "FAILURE", Start: 1551657600},
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297, Result: "SUCCESS", Start: 1554076800},
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 298, Result: "SUCCESS", Start: 1554076900},
+	}
+	for _, b := range builds {
+		if err := e.UpsertBuild(Record{Build: b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if template == "" {
+		t.Error("Index template isn't installed")
+	}
+	expected := map[string]string{
+		"buildanalysis-2019.03/starfish-drd4tv-official-h15#296": "FAILURE 2019-03-04T00:00:00Z",
+		"buildanalysis-2019.04/starfish-drd4tv-official-h15#297": "SUCCESS 2019-04-01T00:00:00Z",
+		"buildanalysis-2019.04/starfish-drd4tv-official-h15#298": "SUCCESS 2019-04-01T00:01:40Z",
+	}
+	if len(indexed) != len(expected) {
+		t.Errorf("Indexed %v, expected %v", indexed, expected)
+	}
+	for k, v := range expected {
+		if indexed[k] != v {
+			t.Errorf("%s: got %q, expected %q", k, indexed[k], v)
+		}
+	}
+	// 429, partial 429, a retry of one build, and the last build on Flush
+	if requests != 4 {
+		t.Errorf("Requests = %d, expected 4", requests)
+	}
+
+	// A build that is stored without a start time, and then with one, is only on a dated index
+	for _, b := range []builddata.BuildData{
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 299},
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 299, Result: "SUCCESS", Start: 1554077000},
+	} {
+		if err := e.UpsertBuild(Record{Build: b}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := indexed["buildanalysis-undated/starfish-drd4tv-official-h15#299"]; ok {
+		t.Error("A build is left on an undated index")
+	}
+	if v := indexed["buildanalysis-2019.04/starfish-drd4tv-official-h15#299"]; v != "SUCCESS 2019-04-01T00:03:20Z" {
+		t.Errorf("Indexed %q on a dated index", v)
+	}
+}
+
+func TestElasticSinkStoredBuilds(t *testing.T) {
+	var filter interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/_search") {
+			fmt.Fprint(w, `{"acknowledged": true}`)
+			return
+		}
+		query := map[string]map[string]map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&query)
+		filter = query["query"]["bool"]["filter"]
+		fmt.Fprint(w, `{"hits": {"hits": [{"_source": {"jobname": "starfish-drd4tv-official-h15", "buildnumber": 297, "result": "SUCCESS"}, "sort": [297]}]}}`)
+	}))
+	defer server.Close()
+
+	expected := map[string]string{
+		// Builds of an empty instance, and builds stored before 'instance' was introduced
+		"":    `[{"term":{"jobname":"starfish-drd4tv-official-h15"}},{"bool":{"minimum_should_match":1,"should":[{"term":{"instance":""}},{"bool":{"must_not":{"exists":{"field":"instance"}}}}]}}]`,
+		"lge": `[{"term":{"jobname":"starfish-drd4tv-official-h15"}},{"term":{"instance":"lge"}}]`,
+	}
+	for instance, expectedFilter := range expected {
+		e := &ElasticSink{Url: server.URL, Index: "buildanalysis", Instance: instance}
+		if err := e.Open(); err != nil {
+			t.Fatal(err)
+		}
+		builds, err := e.StoredBuilds("starfish-drd4tv-official-h15")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(builds) != 1 || builds[0].Buildnumber != 297 {
+			t.Errorf("%q: unexpected builds %+v", instance, builds)
+		}
+		dat, _ := json.Marshal(filter)
+		if string(dat) != expectedFilter {
+			t.Errorf("%q: filter %s, expected %s", instance, dat, expectedFilter)
+		}
+	}
+}
+
+func TestElasticSinkDropped(t *testing.T) {
+	down := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			fmt.Fprint(w, `{"acknowledged": true}`)
+			return
+		}
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// The second build is rejected by a mapping
+		fmt.Fprint(w, `{"errors": true, "items": [{"index": {"status": 201}}, {"index": {"status": 400, "error": {"type": "mapper_parsing_exception"}}}]}`)
+	}))
+	defer server.Close()
+
+	e := &ElasticSink{Url: server.URL, Index: "buildanalysis", BatchSize: 2}
+	if err := e.Open(); err != nil {
+		t.Fatal(err)
+	}
+	path := "/jenkins/jobs/starfish-drd4tv-official-h15/builds/"
+	paths := func(err error) []string {
+		dropped, ok := err.(*DroppedError)
+		if !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		paths := []string{}
+		for _, r := range dropped.Records {
+			paths = append(paths, r.Path)
+		}
+		return paths
+	}
+	// A bulk request is sent by UpsertBuild, and only a build that failed is dropped
+	e.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 1}, Path: path + "1"})
+	err := e.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 2}, Path: path + "2"})
+	if p := paths(err); len(p) != 1 || p[0] != path+"2" {
+		t.Errorf("Dropped %v", p)
+	}
+	// Every build of a request that failed is dropped
+	down = true
+	e.UpsertBuild(Record{Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 3}, Path: path + "3"})
+	if p := paths(e.Flush()); len(p) != 1 || p[0] != path+"3" {
+		t.Errorf("Dropped %v", p)
+	}
+}
@@ -0,0 +1,568 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	mongobson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMongoBatchSize      = 100
+	defaultMongoRetryQueueSize = 1000
+)
+
+// transientMongoCodes are server errors that go away once a replica set has a primary again
+var transientMongoCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isTransientMongo checks if an operation may succeed when it's tried again later
+func isTransientMongo(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		if se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError") {
+			return true
+		}
+		for _, code := range transientMongoCodes {
+			if se.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	// No server can be selected while servers are down or a primary is being elected
+	return strings.Contains(err.Error(), "server selection error")
+}
+
+// storedFields are fields of builds that StoredBuilds and FindBuilds return
+var storedFields = bson.M{
+	"instance": 1, "jobname": 1, "buildnumber": 1, "result": 1, "provisional": 1, "parser_version": 1, "start": 1, "duration": 1,
+	"source_mtime": 1, "source_hash": 1, "source_deleted": 1,
+}
+
+// mongoRegistry encodes records that use types of mgo's bson same as mgo did.
+// An ObjectId of mgo is a raw string of 12 bytes, and documents in interface values
+// are decoded as bson.M
+var mongoRegistry = func() *bsoncodec.Registry {
+	r := mongobson.NewRegistry()
+	objectIdType := reflect.TypeOf(bson.ObjectId(""))
+	r.RegisterTypeEncoder(objectIdType, bsoncodec.ValueEncoderFunc(func(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, v reflect.Value) error {
+		var oid primitive.ObjectID
+		copy(oid[:], v.String())
+		return vw.WriteObjectID(oid)
+	}))
+	r.RegisterTypeDecoder(objectIdType, bsoncodec.ValueDecoderFunc(func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, v reflect.Value) error {
+		oid, err := vr.ReadObjectID()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(oid[:]))
+		return nil
+	}))
+	r.RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(bson.M{}))
+	return r
+}()
+
+// MongoSink writes builds on a collection and their test cases on another collection.
+// Fingerprints and job states are kept on their own collections.
+// Builds are upserted by an instance, a job name and a build number with a unique index,
+// and written in batches of 'BatchSize'. 100 if it's 0.
+// Every routine shares a connection pool of a client. Operations are retried with 'Backoff'
+// (5 retries if it's nil) on network errors and elections, and builds that still can't be written are queued up to
+// 'RetryQueueSize' (1000 if it's 0) to be written with next ones, so a short outage doesn't stop a run
+type MongoSink struct {
+	// Url is a host and a port like 'localhost:27017', or a connection string like
+	// 'mongodb+srv://cluster0.example.com/?replicaSet=rs0&authSource=admin&tls=true'
+	Url             string
+	DBName          string
+	Coll            string
+	TestColl        string
+	FingerprintColl string
+	JobColl         string
+	// ErrorColl keeps builds that couldn't be ingested. They aren't kept if it's empty
+	ErrorColl string
+	// User, Pass, AuthSource and AuthMechanism override ones on 'Url'.
+	// AuthMechanism is like 'SCRAM-SHA-256', and negotiated with a server if it's empty
+	User          string
+	Pass          string
+	AuthSource    string
+	AuthMechanism string
+	// TLSCAFile is a PEM file of CA certificates to verify a server,
+	// and TLSCertFile is a PEM file that has a client certificate and its key.
+	// TLS is used if one of them is set even though 'TLS' is false
+	TLS         bool
+	TLSCAFile   string
+	TLSCertFile string
+	TLSInsecure bool
+	Instance    string
+	BatchSize   int
+	// Migrate gives an empty instance to builds that were stored before 'instance' was introduced,
+	// and removes duplicates of builds that were stored by concurrent runs, when it opens a collection.
+	// A unique index of builds can't be created until it's done once
+	Migrate bool
+
+	Backoff        *Backoff
+	RetryQueueSize int
+
+	client  *mongo.Client
+	db      *mongo.Database
+	mutex   sync.Mutex
+	pending []Record
+	queue   []Record
+}
+
+func (m *MongoSink) retry(fn func() error) error {
+	if m.Backoff == nil {
+		return Backoff{MaxRetries: defaultMaxRetries}.Retry(isTransientMongo, fn)
+	}
+	return m.Backoff.Retry(isTransientMongo, fn)
+}
+
+// instanceKey selects records of an instance. Records before 'instance' was introduced
+// don't have it until they are migrated, and they are of the empty instance
+func (m *MongoSink) instanceKey() interface{} {
+	if m.Instance == "" {
+		return bson.M{"$in": []interface{}{nil, ""}}
+	}
+	return m.Instance
+}
+
+// buildKey selects a build of a job on an instance
+func (m *MongoSink) buildKey(jobName string, buildNumber int) bson.M {
+	return bson.M{"instance": m.instanceKey(), "jobname": jobName, "buildnumber": buildNumber}
+}
+
+// clientOptions makes options to connect from 'Url' and options that override it
+func (m *MongoSink) clientOptions() (*options.ClientOptions, error) {
+	uri := m.Url
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		uri = "mongodb://" + uri
+	}
+	opts := options.Client().ApplyURI(uri).SetRegistry(mongoRegistry)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if m.User != "" || m.AuthSource != "" || m.AuthMechanism != "" {
+		auth := options.Credential{}
+		if opts.Auth != nil {
+			auth = *opts.Auth
+		}
+		if m.User != "" {
+			auth.Username = m.User
+			auth.Password = m.Pass
+			auth.PasswordSet = true
+		}
+		if m.AuthSource != "" {
+			auth.AuthSource = m.AuthSource
+		}
+		if m.AuthMechanism != "" {
+			auth.AuthMechanism = m.AuthMechanism
+		}
+		opts.SetAuth(auth)
+	}
+	if m.TLS || m.TLSCAFile != "" || m.TLSCertFile != "" || m.TLSInsecure {
+		config := opts.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if m.TLSCAFile != "" {
+			pem, err := ioutil.ReadFile(m.TLSCAFile)
+			if err != nil {
+				return nil, err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.New("No CA certificate in " + m.TLSCAFile)
+			}
+		}
+		if m.TLSCertFile != "" {
+			pem, err := ioutil.ReadFile(m.TLSCertFile)
+			if err != nil {
+				return nil, err
+			}
+			cert, err := tls.X509KeyPair(pem, pem)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot load a client certificate %s: %v", m.TLSCertFile, err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		config.InsecureSkipVerify = m.TLSInsecure
+		opts.SetTLSConfig(config)
+	}
+	return opts, nil
+}
+
+// Open connects to MongoDB and checks that it's authenticated,
+// so wrong credentials stop a run before any build is analyzed
+func (m *MongoSink) Open() error {
+	opts, err := m.clientOptions()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	// Connect doesn't talk to a server. Ping does, and authenticates
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return fmt.Errorf("Cannot connect to MongoDB: %v", err)
+	}
+	m.client = client
+	m.db = client.Database(m.DBName)
+	if m.BatchSize == 0 {
+		m.BatchSize = defaultMongoBatchSize
+	}
+	if m.RetryQueueSize == 0 {
+		m.RetryQueueSize = defaultMongoRetryQueueSize
+	}
+	if m.Migrate {
+		if err := m.retry(m.migrate); err != nil {
+			return err
+		}
+	}
+	return m.retry(m.ensureIndexes)
+}
+
+// migrate gives an empty instance to records before 'instance' was introduced,
+// and removes duplicates of builds except the latest one. It logs what it has changed
+func (m *MongoSink) migrate() error {
+	ctx := context.Background()
+	coll := m.db.Collection(m.Coll)
+	result, err := coll.UpdateMany(ctx, bson.M{"instance": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"instance": ""}})
+	if err != nil {
+		return err
+	}
+	log.Println("INFO: Set an empty instance on builds without one: ", result.ModifiedCount)
+	cursor, err := coll.Aggregate(ctx, []bson.M{
+		{"$sort": bson.M{"_id": 1}},
+		{"$group": bson.M{
+			"_id":   bson.M{"instance": "$instance", "jobname": "$jobname", "buildnumber": "$buildnumber"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	var duplicates []struct {
+		ID struct {
+			Instance    string
+			Jobname     string
+			Buildnumber int
+		} `bson:"_id"`
+		Ids []interface{}
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+	removed := 0
+	for _, d := range duplicates {
+		ids := d.Ids[:len(d.Ids)-1]
+		result, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		log.Printf("INFO: Removed %d duplicates of %s #%d of instance %q", result.DeletedCount, d.ID.Jobname, d.ID.Buildnumber, d.ID.Instance)
+		removed += int(result.DeletedCount)
+	}
+	log.Println("INFO: Removed duplicated builds: ", removed)
+	return nil
+}
+
+// ensureIndexes creates a unique index of builds, and indexes of other collections
+func (m *MongoSink) ensureIndexes() error {
+	ctx := context.Background()
+	coll := m.db.Collection(m.Coll)
+	// An index on fields that records never had, which made nothing unique
+	coll.Indexes().DropOne(ctx, "buildjob_1_data_1")
+	buildKeys := mongobson.D{{Key: "instance", Value: 1}, {Key: "jobname", Value: 1}, {Key: "buildnumber", Value: 1}}
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: buildKeys, Options: options.Index().SetUnique(true)})
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("Builds have duplicates, run once with '-dbMigrate' to remove them: %v", err)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := m.db.Collection(m.TestColl).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: buildKeys}); err != nil {
+		return err
+	}
+	if m.ErrorColl != "" {
+		_, err = m.db.Collection(m.ErrorColl).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    mongobson.D{{Key: "instance", Value: 1}, {Key: "path", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if m.FingerprintColl == "" {
+		return nil
+	}
+	_, err = m.db.Collection(m.FingerprintColl).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: mongobson.D{{Key: "md5", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: mongobson.D{{Key: "original.jobname", Value: 1}, {Key: "original.buildnumber", Value: 1}}},
+	})
+	return err
+}
+
+// UpsertBuild queues a build and writes queued builds once there are 'BatchSize' of them
+func (m *MongoSink) UpsertBuild(r Record) error {
+	m.mutex.Lock()
+	m.pending = append(m.pending, r)
+	if len(m.pending) < m.BatchSize {
+		m.mutex.Unlock()
+		return nil
+	}
+	records := m.pending
+	m.pending = nil
+	m.mutex.Unlock()
+	return m.write(records)
+}
+
+// Flush writes queued builds, and returns an error if some of them are still left to retry.
+// It returns a *DroppedError itself only if the other builds have been written
+func (m *MongoSink) Flush() error {
+	m.mutex.Lock()
+	records := m.pending
+	m.pending = nil
+	isEmpty := len(records) == 0 && len(m.queue) == 0
+	m.mutex.Unlock()
+	if isEmpty {
+		return nil
+	}
+	err := m.write(records)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.queue) > 0 && err != nil {
+		return fmt.Errorf("%w, and %d builds are queued to retry", err, len(m.queue))
+	}
+	if len(m.queue) > 0 {
+		return fmt.Errorf("%d builds couldn't be written, and will be analyzed again on a next run", len(m.queue))
+	}
+	return err
+}
+
+// write writes builds with builds that failed before. Builds are queued again if they fail
+// with a transient error after retries, and the oldest ones are dropped with a *DroppedError
+// if the queue is full. Every build is dropped with a *DroppedError if they fail with another error
+func (m *MongoSink) write(records []Record) error {
+	m.mutex.Lock()
+	records = append(m.queue, records...)
+	m.queue = nil
+	m.mutex.Unlock()
+	err := m.retry(func() error {
+		return m.bulkWrite(records)
+	})
+	if err == nil {
+		return nil
+	}
+	if !isTransientMongo(err) {
+		return &DroppedError{Records: records, Err: err}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.queue = append(m.queue, records...)
+	if over := len(m.queue) - m.RetryQueueSize; over > 0 {
+		dropped := append([]Record{}, m.queue[:over]...)
+		m.queue = m.queue[over:]
+		return &DroppedError{Records: dropped, Err: err}
+	}
+	log.Printf("WARNING: Cannot write %d builds, queued to retry: %v", len(records), err)
+	return nil
+}
+
+// bulkWrite upserts builds with a bulk request. Each build is replaced atomically,
+// so a stored build is never missing even if a run stops in the middle.
+// Test cases of a build are replaced by the build's ones if it has test results.
+// Both are idempotent, so they can be retried
+func (m *MongoSink) bulkWrite(records []Record) error {
+	ctx := context.Background()
+	builds, testCases := m.writeModels(records)
+	_, err := m.db.Collection(m.Coll).BulkWrite(ctx, builds, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	if len(testCases) > 0 {
+		_, err = m.db.Collection(m.TestColl).BulkWrite(ctx, testCases, options.BulkWrite().SetOrdered(true))
+	}
+	return err
+}
+
+// writeModels returns upserts of builds, and deletes and inserts of test cases in order
+func (m *MongoSink) writeModels(records []Record) (builds []mongo.WriteModel, testCases []mongo.WriteModel) {
+	for i := range records {
+		b := &records[i].Build
+		b.Instance = m.Instance
+		builds = append(builds, mongo.NewReplaceOneModel().SetFilter(m.buildKey(b.Jobname, b.Buildnumber)).SetReplacement(b).SetUpsert(true))
+		if !records[i].HasTestResult {
+			continue
+		}
+		// Test cases have to be removed before new ones are inserted
+		testCases = append(testCases, mongo.NewDeleteManyModel().SetFilter(m.buildKey(b.Jobname, b.Buildnumber)))
+		for j := range records[i].TestCases {
+			records[i].TestCases[j].Instance = m.Instance
+			testCases = append(testCases, mongo.NewInsertOneModel().SetDocument(&records[i].TestCases[j]))
+		}
+	}
+	return builds, testCases
+}
+
+func (m *MongoSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	return m.FindBuilds(jobName, bson.M{})
+}
+
+func (m *MongoSink) Build(jobName string, buildNumber int) (builddata.BuildData, error) {
+	v := builddata.BuildData{}
+	err := m.db.Collection(m.Coll).FindOne(context.Background(), m.buildKey(jobName, buildNumber)).Decode(&v)
+	if err == mongo.ErrNoDocuments {
+		return v, ErrNotFound
+	}
+	return v, err
+}
+
+func (m *MongoSink) FindBuilds(jobName string, filter bson.M) ([]builddata.BuildData, error) {
+	ctx := context.Background()
+	query := bson.M{}
+	for k, v := range filter {
+		query[k] = v
+	}
+	query["instance"] = m.instanceKey()
+	query["jobname"] = jobName
+	builds := []builddata.BuildData{}
+	opts := options.Find().SetProjection(storedFields).SetSort(mongobson.D{{Key: "buildnumber", Value: 1}})
+	err := m.retry(func() error {
+		cursor, err := m.db.Collection(m.Coll).Find(ctx, query, opts)
+		if err != nil {
+			return err
+		}
+		builds = []builddata.BuildData{}
+		return cursor.All(ctx, &builds)
+	})
+	return builds, err
+}
+
+func (m *MongoSink) JobNames() ([]string, error) {
+	values, err := m.db.Collection(m.Coll).Distinct(context.Background(), "jobname", bson.M{"instance": m.instanceKey()})
+	if err != nil {
+		return nil, err
+	}
+	jobNames := make([]string, 0, len(values))
+	for _, v := range values {
+		if jobName, ok := v.(string); ok {
+			jobNames = append(jobNames, jobName)
+		}
+	}
+	return jobNames, nil
+}
+
+func (m *MongoSink) MarkDeleted(jobName string, buildNumber int) error {
+	_, err := m.db.Collection(m.Coll).UpdateMany(context.Background(), m.buildKey(jobName, buildNumber),
+		bson.M{"$set": bson.M{"source_deleted": true}})
+	return err
+}
+
+// UpsertFingerprint stores a fingerprint keyed by an MD5 checksum
+func (m *MongoSink) UpsertFingerprint(f builddata.FingerprintData) error {
+	return m.retry(func() error {
+		_, err := m.db.Collection(m.FingerprintColl).ReplaceOne(context.Background(), bson.M{"md5": f.Md5}, &f,
+			options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+// RecordError stores a build that couldn't be ingested, keyed by an instance and a build directory
+func (m *MongoSink) RecordError(e builddata.IngestErrorData) error {
+	if m.ErrorColl == "" {
+		return nil
+	}
+	e.Instance = m.Instance
+	return m.retry(func() error {
+		_, err := m.db.Collection(m.ErrorColl).ReplaceOne(context.Background(), bson.M{"instance": e.Instance, "path": e.Path}, &e,
+			options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+// ClearErrors removes errors of build directories of an instance
+func (m *MongoSink) ClearErrors(paths []string) error {
+	if m.ErrorColl == "" || len(paths) == 0 {
+		return nil
+	}
+	return m.retry(func() error {
+		_, err := m.db.Collection(m.ErrorColl).DeleteMany(context.Background(), bson.M{"instance": m.Instance, "path": bson.M{"$in": paths}})
+		return err
+	})
+}
+
+func (m *MongoSink) FingerprintsOf(jobName string, buildNumber int) ([]builddata.FingerprintData, error) {
+	ctx := context.Background()
+	fingerprints := []builddata.FingerprintData{}
+	cursor, err := m.db.Collection(m.FingerprintColl).Find(ctx,
+		bson.M{"original.jobname": jobName, "original.buildnumber": buildNumber},
+		options.Find().SetSort(mongobson.D{{Key: "filename", Value: 1}}))
+	if err != nil {
+		return fingerprints, err
+	}
+	err = cursor.All(ctx, &fingerprints)
+	return fingerprints, err
+}
+
+// UpsertJob stores a job state keyed by a job name
+func (m *MongoSink) UpsertJob(j builddata.JobData) error {
+	return m.retry(func() error {
+		_, err := m.db.Collection(m.JobColl).ReplaceOne(context.Background(), bson.M{"jobname": j.Jobname}, &j,
+			options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (m *MongoSink) Job(jobName string) (builddata.JobData, error) {
+	j := builddata.JobData{}
+	err := m.db.Collection(m.JobColl).FindOne(context.Background(), bson.M{"jobname": jobName}).Decode(&j)
+	if err == mongo.ErrNoDocuments {
+		return j, ErrNotFound
+	}
+	return j, err
+}
+
+func (m *MongoSink) Close() error {
+	if m.client != nil {
+		return m.client.Disconnect(context.Background())
+	}
+	return nil
+}
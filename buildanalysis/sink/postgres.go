@@ -0,0 +1,438 @@
+package sink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/lib/pq"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// postgresMigrations are applied in order and each of them only once.
+// Append a new migration instead of editing applied ones
+var postgresMigrations = []string{
+	`CREATE TABLE builds (
+		id                BIGSERIAL PRIMARY KEY,
+		jobname           TEXT NOT NULL,
+		buildnumber       INTEGER NOT NULL,
+		result            TEXT NOT NULL DEFAULT '',
+		host              TEXT NOT NULL DEFAULT '',
+		machine           TEXT NOT NULL DEFAULT '',
+		workspace         TEXT NOT NULL DEFAULT '',
+		description       TEXT NOT NULL DEFAULT '',
+		start_time        TIMESTAMPTZ,
+		duration          INTEGER NOT NULL DEFAULT 0,
+		waiting           INTEGER NOT NULL DEFAULT 0,
+		duration_in_queue DOUBLE PRECISION NOT NULL DEFAULT 0,
+		timediff          INTEGER NOT NULL DEFAULT 0,
+		cause             JSONB,
+		git_change        JSONB,
+		environment       JSONB,
+		tests_total       INTEGER NOT NULL DEFAULT 0,
+		tests_passed      INTEGER NOT NULL DEFAULT 0,
+		tests_failed      INTEGER NOT NULL DEFAULT 0,
+		tests_skipped     INTEGER NOT NULL DEFAULT 0,
+		tests_duration    DOUBLE PRECISION NOT NULL DEFAULT 0,
+		artifacts_count   INTEGER NOT NULL DEFAULT 0,
+		artifacts_size    BIGINT NOT NULL DEFAULT 0,
+		provisional       BOOLEAN NOT NULL DEFAULT FALSE,
+		source_mtime      BIGINT NOT NULL DEFAULT 0,
+		source_hash       TEXT NOT NULL DEFAULT '',
+		parser_version    INTEGER NOT NULL DEFAULT 0,
+		source_deleted    BOOLEAN NOT NULL DEFAULT FALSE,
+		UNIQUE (jobname, buildnumber)
+	)`,
+	`CREATE TABLE build_parameters (
+		build_id BIGINT NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+		name     TEXT NOT NULL,
+		value    TEXT NOT NULL,
+		PRIMARY KEY (build_id, name)
+	)`,
+	`CREATE TABLE gerrit_changes (
+		build_id           BIGINT PRIMARY KEY REFERENCES builds(id) ON DELETE CASCADE,
+		project            TEXT NOT NULL,
+		branch             TEXT NOT NULL,
+		changenumber       INTEGER NOT NULL,
+		changeid           TEXT NOT NULL,
+		url                TEXT NOT NULL,
+		receivedon         TIMESTAMPTZ,
+		patchset_number    INTEGER NOT NULL,
+		patchset_ref       TEXT NOT NULL,
+		patchset_createdon TEXT NOT NULL,
+		author_name        TEXT NOT NULL,
+		author_email       TEXT NOT NULL,
+		uploader_name      TEXT NOT NULL,
+		uploader_email     TEXT NOT NULL
+	)`,
+	`CREATE INDEX gerrit_changes_changenumber ON gerrit_changes (changenumber)`,
+	`CREATE TABLE build_timings (
+		build_id BIGINT NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+		name     TEXT NOT NULL,
+		seconds  DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (build_id, name)
+	)`,
+	`CREATE TABLE build_failures (
+		build_id BIGINT NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+		seq      INTEGER NOT NULL,
+		message  TEXT NOT NULL,
+		PRIMARY KEY (build_id, seq)
+	)`,
+	`CREATE TABLE build_layers (
+		build_id BIGINT NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+		name     TEXT NOT NULL,
+		branch   TEXT NOT NULL,
+		revision TEXT NOT NULL,
+		PRIMARY KEY (build_id, name)
+	)`,
+	`CREATE TABLE test_cases (
+		build_id      BIGINT NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+		suite         TEXT NOT NULL,
+		classname     TEXT NOT NULL,
+		name          TEXT NOT NULL,
+		duration      DOUBLE PRECISION NOT NULL,
+		status        TEXT NOT NULL,
+		failed_since  INTEGER NOT NULL,
+		error_message TEXT NOT NULL
+	)`,
+	`CREATE INDEX test_cases_build_id ON test_cases (build_id)`,
+	`ALTER TABLE builds ADD COLUMN instance TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE builds DROP CONSTRAINT builds_jobname_buildnumber_key`,
+	`ALTER TABLE builds ADD CONSTRAINT builds_instance_jobname_buildnumber_key UNIQUE (instance, jobname, buildnumber)`,
+	`CREATE TABLE ingest_errors (
+		instance    TEXT NOT NULL,
+		path        TEXT NOT NULL,
+		jobname     TEXT NOT NULL,
+		buildnumber INTEGER NOT NULL,
+		stage       TEXT NOT NULL,
+		message     TEXT NOT NULL,
+		time        TIMESTAMPTZ,
+		PRIMARY KEY (instance, path)
+	)`,
+}
+
+var buildColumns = []string{
+	"instance", "jobname", "buildnumber", "result", "host", "machine", "workspace", "description",
+	"start_time", "duration", "waiting", "duration_in_queue", "timediff",
+	"cause", "git_change", "environment",
+	"tests_total", "tests_passed", "tests_failed", "tests_skipped", "tests_duration",
+	"artifacts_count", "artifacts_size",
+	"provisional", "source_mtime", "source_hash", "parser_version", "source_deleted",
+}
+
+// upsertBuildSql inserts a build or updates every column of a stored one, so its id is kept
+var upsertBuildSql = func() string {
+	values := make([]string, len(buildColumns))
+	updates := []string{}
+	for i, c := range buildColumns {
+		values[i] = "$" + strconv.Itoa(i+1)
+		if c != "instance" && c != "jobname" && c != "buildnumber" {
+			updates = append(updates, c+" = EXCLUDED."+c)
+		}
+	}
+	return "INSERT INTO builds (" + strings.Join(buildColumns, ", ") + ") VALUES (" + strings.Join(values, ", ") + ")" +
+		" ON CONFLICT (instance, jobname, buildnumber) DO UPDATE SET " + strings.Join(updates, ", ") + " RETURNING id"
+}()
+
+const defaultPostgresBatchSize = 100
+
+// PostgresSink writes builds on a relational schema.
+// A build is a row of 'builds' and its parameters, gerrit change, timings, failures,
+// layers and test cases are rows of their own tables that refer to it.
+// Builds are written in batches of 'BatchSize' (100 if it's 0), each batch in a transaction
+type PostgresSink struct {
+	Url       string
+	Instance  string
+	BatchSize int
+
+	db      *sql.DB
+	mutex   sync.Mutex
+	pending []Record
+}
+
+func (p *PostgresSink) Open() error {
+	db, err := sql.Open("postgres", p.Url)
+	if err != nil {
+		return err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+	p.db = db
+	if p.BatchSize == 0 {
+		p.BatchSize = defaultPostgresBatchSize
+	}
+	return p.migrate()
+}
+
+// migrate applies migrations that haven't been applied yet
+func (p *PostgresSink) migrate() error {
+	_, err := p.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := p.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(postgresMigrations); i++ {
+		tx, err := p.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(postgresMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonb(m bson.M) []byte {
+	if m == nil {
+		return nil
+	}
+	dat, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return dat
+}
+
+func str(m bson.M, k string) string {
+	if v, ok := m[k]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func num(m bson.M, k string) int {
+	n, _ := strconv.Atoi(str(m, k))
+	return n
+}
+
+func sub(m bson.M, k string) bson.M {
+	if v, ok := m[k].(bson.M); ok {
+		return v
+	}
+	return bson.M{}
+}
+
+func unixTime(sec int) interface{} {
+	if sec == 0 {
+		return nil
+	}
+	return time.Unix(int64(sec), 0)
+}
+
+// UpsertBuild queues a build and writes queued builds once there are 'BatchSize' of them
+func (p *PostgresSink) UpsertBuild(r Record) error {
+	p.mutex.Lock()
+	p.pending = append(p.pending, r)
+	if len(p.pending) < p.BatchSize {
+		p.mutex.Unlock()
+		return nil
+	}
+	records := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+	return p.write(records)
+}
+
+// Flush writes queued builds
+func (p *PostgresSink) Flush() error {
+	p.mutex.Lock()
+	records := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	return p.write(records)
+}
+
+// write writes builds in a transaction. A build that fails is rolled back to a savepoint,
+// so other builds are committed, and builds that aren't written are returned with a *DroppedError
+func (p *PostgresSink) write(records []Record) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return &DroppedError{Records: records, Err: err}
+	}
+	dropped := []Record{}
+	var dropErr error
+	for _, r := range records {
+		if _, err := tx.Exec(`SAVEPOINT build`); err != nil {
+			tx.Rollback()
+			return &DroppedError{Records: records, Err: err}
+		}
+		if err := p.upsertBuild(tx, r); err != nil {
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT build`); err != nil {
+				tx.Rollback()
+				return &DroppedError{Records: records, Err: err}
+			}
+			dropped = append(dropped, r)
+			dropErr = err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return &DroppedError{Records: records, Err: err}
+	}
+	if len(dropped) > 0 {
+		return &DroppedError{Records: dropped, Err: dropErr}
+	}
+	return nil
+}
+
+// upsertBuild updates a stored build in place or inserts a new one, and replaces its rows
+// on other tables. Test cases are replaced only if a build has test results
+func (p *PostgresSink) upsertBuild(tx *sql.Tx, r Record) (err error) {
+	b := r.Build
+	var id int64
+	err = tx.QueryRow(upsertBuildSql,
+		p.Instance, b.Jobname, b.Buildnumber, b.Result, b.Host, b.Machine, b.Workspace, b.Description,
+		unixTime(b.Start), b.Duration, b.Waiting, b.Duration_in_queue, b.Timediff,
+		jsonb(b.Cause), jsonb(b.GitChangeInfo), jsonb(b.Environment),
+		b.Tests_total, b.Tests_passed, b.Tests_failed, b.Tests_skipped, b.Tests_duration,
+		b.Artifacts_count, b.Artifacts_size,
+		b.Provisional, b.Source_mtime, b.Source_hash, b.Parser_version, b.Source_deleted,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{"build_parameters", "gerrit_changes", "build_timings", "build_failures", "build_layers"}
+	if r.HasTestResult {
+		tables = append(tables, "test_cases")
+	}
+	for _, t := range tables {
+		if _, err = tx.Exec(`DELETE FROM `+t+` WHERE build_id = $1`, id); err != nil {
+			return err
+		}
+	}
+
+	for k := range b.Parameters {
+		_, err = tx.Exec(`INSERT INTO build_parameters (build_id, name, value) VALUES ($1, $2, $3)`, id, k, str(b.Parameters, k))
+		if err != nil {
+			return err
+		}
+	}
+
+	g := b.GerritChangeInfo
+	if str(g, "project") != "" || num(g, "changenumber") != 0 {
+		patchset := sub(g, "patchset")
+		author := sub(patchset, "author")
+		uploader := sub(patchset, "uploader")
+		_, err = tx.Exec(`INSERT INTO gerrit_changes (build_id, project, branch, changenumber, changeid, url, receivedon,
+			patchset_number, patchset_ref, patchset_createdon, author_name, author_email, uploader_name, uploader_email)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			id, str(g, "project"), str(g, "branch"), num(g, "changenumber"), str(g, "changeid"), str(g, "url"), unixTime(num(g, "receivedon")),
+			num(patchset, "number"), str(patchset, "ref"), str(patchset, "createdon"),
+			str(author, "name"), str(author, "email"), str(uploader, "name"), str(uploader, "email"))
+		if err != nil {
+			return err
+		}
+	}
+
+	timings := map[string]float64{
+		"build_sh":           b.Time_build_sh,
+		"bitbake":            b.Time_bitbake,
+		"rm_BUILD":           b.Time_rm_BUILD,
+		"rm_BUILD_ARTIFACTS": b.Time_rm_BUILD_ARTIFACTS,
+		"rm_downloads":       b.Time_rm_downloads,
+		"rm_sstate":          b.Time_rm_sstate,
+		"rsync_artifacts":    b.Time_rsync_artifacts,
+	}
+	for k, v := range timings {
+		// 0 means a step isn't found on a build log
+		if v == 0 {
+			continue
+		}
+		if _, err = tx.Exec(`INSERT INTO build_timings (build_id, name, seconds) VALUES ($1, $2, $3)`, id, k, v); err != nil {
+			return err
+		}
+	}
+
+	for i, f := range b.Failures {
+		if _, err = tx.Exec(`INSERT INTO build_failures (build_id, seq, message) VALUES ($1, $2, $3)`, id, i, f); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range b.Layers {
+		_, err = tx.Exec(`INSERT INTO build_layers (build_id, name, branch, revision) VALUES ($1, $2, $3, $4)`, id, l.Name, l.Branch, l.Revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.HasTestResult {
+		for _, c := range r.TestCases {
+			_, err = tx.Exec(`INSERT INTO test_cases (build_id, suite, classname, name, duration, status, failed_since, error_message)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				id, c.Suite, c.Classname, c.Name, c.Duration, c.Status, c.Failed_since, c.Error_message)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *PostgresSink) Close() error {
+	if p.db != nil {
+		return p.db.Close()
+	}
+	return nil
+}
+
+// RecordError stores a build that couldn't be ingested, keyed by an instance and a build directory
+func (p *PostgresSink) RecordError(e builddata.IngestErrorData) error {
+	_, err := p.db.Exec(`INSERT INTO ingest_errors (instance, path, jobname, buildnumber, stage, message, time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (instance, path) DO UPDATE SET jobname = EXCLUDED.jobname, buildnumber = EXCLUDED.buildnumber,
+		stage = EXCLUDED.stage, message = EXCLUDED.message, time = EXCLUDED.time`,
+		p.Instance, e.Path, e.Jobname, e.Buildnumber, e.Stage, e.Message, unixTime(e.Time))
+	return err
+}
+
+// ClearErrors removes errors of build directories of an instance
+func (p *PostgresSink) ClearErrors(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := p.db.Exec(`DELETE FROM ingest_errors WHERE instance = $1 AND path = ANY($2)`, p.Instance, pq.Array(paths))
+	return err
+}
+
+func (p *PostgresSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	rows, err := p.db.Query(`SELECT jobname, buildnumber, result, provisional, parser_version,
+		COALESCE(EXTRACT(EPOCH FROM start_time), 0)::INTEGER, duration, source_mtime, source_hash, source_deleted
+		FROM builds WHERE instance = $1 AND jobname = $2 ORDER BY buildnumber`, p.Instance, jobName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	builds := []builddata.BuildData{}
+	for rows.Next() {
+		v := builddata.BuildData{}
+		err := rows.Scan(&v.Jobname, &v.Buildnumber, &v.Result, &v.Provisional, &v.Parser_version,
+			&v.Start, &v.Duration, &v.Source_mtime, &v.Source_hash, &v.Source_deleted)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, v)
+	}
+	return builds, rows.Err()
+}
@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+)
+
+// TestPostgresSink runs against a local PostgreSQL instance, e.g.
+// BUILDANALYSIS_POSTGRES_URL='postgres://postgres@localhost/buildanalysis_test?sslmode=disable'
+func TestPostgresSink(t *testing.T) {
+	url := os.Getenv("BUILDANALYSIS_POSTGRES_URL")
+	if url == "" {
+		t.Skip("BUILDANALYSIS_POSTGRES_URL isn't set")
+	}
+	p := &PostgresSink{Url: url}
+	if err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.db.Exec(`DELETE FROM builds WHERE jobname = 'buildanalysis-test-job-h15'`)
+
+	build := builddata.BuildData{
+		Jobname:     "buildanalysis-test-job-h15",
+		Buildnumber: 297,
+		Result:      "FAILURE",
+		Parameters:  bson.M{"MACHINE": "h15"},
+		GerritChangeInfo: bson.M{
+			"project":      "webos/build",
+			"changenumber": 97589,
+			"patchset":     bson.M{"number": 2, "author": bson.M{"name": "dev"}},
+		},
+		Time_bitbake: 3600.5,
+		Failures:     []string{"ERROR: Task do_compile failed"},
+		Layers:       []builddata.LayerData{{Name: "meta", Branch: "HEAD", Revision: "0123abcd"}},
+	}
+	cases := []builddata.TestCaseData{{Suite: "ptest", Name: "gvariant", Status: "FAILED"}}
+	if err := p.UpsertBuild(Record{Build: build, TestCases: cases, HasTestResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	// The second upsert replaces the first one, but keeps test cases
+	build.Result = "SUCCESS"
+	build.Failures = nil
+	if err := p.UpsertBuild(Record{Build: build}); err != nil {
+		t.Fatal(err)
+	}
+	// A build that can't be written is dropped, and the others of a batch are committed
+	invalid := builddata.BuildData{Jobname: "buildanalysis-test-job-h15", Buildnumber: 298, Result: "FAIL\x00URE"}
+	if err := p.UpsertBuild(Record{Build: invalid, Path: "/jenkins/jobs/buildanalysis-test-job-h15/builds/298"}); err != nil {
+		t.Fatal(err)
+	}
+	err := p.Flush()
+	dropped, ok := err.(*DroppedError)
+	if !ok || len(dropped.Records) != 1 || dropped.Records[0].Build.Buildnumber != 298 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	builds, err := p.StoredBuilds(build.Jobname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 1 || builds[0].Result != "SUCCESS" {
+		t.Fatalf("unexpected builds: %+v", builds)
+	}
+	counts := map[string]int{"build_failures": 0, "test_cases": 1, "gerrit_changes": 1, "build_timings": 1, "build_layers": 1, "build_parameters": 1}
+	for table, expected := range counts {
+		var n int
+		err := p.db.QueryRow(`SELECT COUNT(*) FROM `+table+` t JOIN builds b ON b.id = t.build_id WHERE b.jobname = $1`, build.Jobname).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Errorf("%s: %d rows, expected %d", table, n, expected)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultRetryWait  = time.Second
+	defaultMaxWait    = 30 * time.Second
+)
+
+// Backoff retries an operation with exponential backoff, e.g. while a replica set elects a new primary
+type Backoff struct {
+	// MaxRetries: retries after a first try. An operation isn't retried if it's 0
+	// Wait: a wait before a first retry, and it doubles on every retry. 1 second if it's 0
+	// MaxWait: the longest wait. 30 seconds if it's 0
+	MaxRetries int
+	Wait       time.Duration
+	MaxWait    time.Duration
+}
+
+// Retry calls 'fn' until it succeeds, it fails with an error that 'isTransient' doesn't accept,
+// or it has been retried 'MaxRetries' times. It returns the last error
+func (b Backoff) Retry(isTransient func(error) bool, fn func() error) error {
+	maxRetries, wait, maxWait := b.MaxRetries, b.Wait, b.MaxWait
+	if wait == 0 {
+		wait = defaultRetryWait
+	}
+	if maxWait == 0 {
+		maxWait = defaultMaxWait
+	}
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil || !isTransient(err) || retry >= maxRetries {
+			return err
+		}
+		log.Printf("WARNING: Retry in %v: %v", wait, err)
+		time.Sleep(wait)
+		wait *= 2
+		if wait > maxWait {
+			wait = maxWait
+		}
+	}
+}
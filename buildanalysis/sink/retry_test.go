@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+func ExampleBackoff_Retry() {
+	errTransient := errors.New("not primary")
+	isTransient := func(err error) bool { return err == errTransient }
+	b := Backoff{MaxRetries: 3, Wait: time.Millisecond}
+
+	calls := 0
+	err := b.Retry(isTransient, func() error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	fmt.Println(calls, err)
+
+	calls = 0
+	err = b.Retry(isTransient, func() error {
+		calls++
+		return errTransient
+	})
+	fmt.Println(calls, err)
+
+	calls = 0
+	err = b.Retry(isTransient, func() error {
+		calls++
+		return errors.New("duplicate key")
+	})
+	fmt.Println(calls, err)
+
+	// 0 retries tries once
+	calls = 0
+	err = Backoff{}.Retry(isTransient, func() error {
+		calls++
+		return errTransient
+	})
+	fmt.Println(calls, err)
+	// Output:
+	// 3 <nil>
+	// 4 not primary
+	// 1 duplicate key
+	// 1 not primary
+}
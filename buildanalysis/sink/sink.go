@@ -0,0 +1,243 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Record is what is written for a build.
+// Test cases replace stored ones only if HasTestResult is true
+type Record struct {
+	Build         builddata.BuildData
+	TestCases     []builddata.TestCaseData
+	HasTestResult bool
+	// Path is a build directory that a record has been analyzed from. It isn't stored
+	Path string
+}
+
+// Sink is a store that parsed builds are written to.
+// UpsertBuild replaces a stored build with the same job name and build number,
+// and may buffer writes until Flush. StoredBuilds returns builds of a job with at least
+// job names, build numbers, results and source fields to find builds to analyze.
+// Implementations must be safe for concurrent use
+type Sink interface {
+	Open() error
+	UpsertBuild(r Record) error
+	Flush() error
+	Close() error
+	StoredBuilds(jobName string) ([]builddata.BuildData, error)
+}
+
+// Store is a sink that also answers queries for reports, and keeps fingerprints and job states.
+// FindBuilds matches 'filter' against documents that have the same field names as
+// MongoDB records, and returns the same fields as StoredBuilds
+type Store interface {
+	Sink
+	Build(jobName string, buildNumber int) (builddata.BuildData, error)
+	FindBuilds(jobName string, filter bson.M) ([]builddata.BuildData, error)
+	JobNames() ([]string, error)
+	MarkDeleted(jobName string, buildNumber int) error
+	UpsertFingerprint(f builddata.FingerprintData) error
+	FingerprintsOf(jobName string, buildNumber int) ([]builddata.FingerprintData, error)
+	UpsertJob(j builddata.JobData) error
+	Job(jobName string) (builddata.JobData, error)
+}
+
+// ErrorRecorder is a sink that keeps builds that couldn't be ingested, so they can be looked into
+// after a run. RecordError replaces an error of the same instance and path,
+// and ClearErrors removes errors of build directories that have been written since
+type ErrorRecorder interface {
+	RecordError(e builddata.IngestErrorData) error
+	ClearErrors(paths []string) error
+}
+
+// ErrNotFound is returned by a Store if a build or a job isn't stored
+var ErrNotFound = errors.New("not found")
+
+// DroppedError is returned by a sink that has given up writing records, e.g. when its retry queue is full
+// or a batch fails. Records haven't been written and have to be analyzed again, and they can be records
+// of previous calls. UpsertBuild keeps other records to write them later. Flush has written other records
+// if it returns a DroppedError itself, and may not have if it's wrapped in another error
+type DroppedError struct {
+	Records []Record
+	Err     error
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("Dropped %d builds that couldn't be written: %v", len(e.Records), e.Err)
+}
+
+func (e *DroppedError) Unwrap() error {
+	return e.Err
+}
+
+func key(jobName string, buildNumber int) string {
+	return jobName + "#" + strconv.Itoa(buildNumber)
+}
+
+// MemorySink keeps records, fingerprints and job states in memory. It's for tests
+type MemorySink struct {
+	mutex        sync.Mutex
+	records      map[string]Record
+	fingerprints map[string]builddata.FingerprintData
+	jobs         map[string]builddata.JobData
+	Flushed      int
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		records:      make(map[string]Record),
+		fingerprints: make(map[string]builddata.FingerprintData),
+		jobs:         make(map[string]builddata.JobData),
+	}
+}
+
+func (m *MemorySink) Open() error {
+	return nil
+}
+
+func (m *MemorySink) UpsertBuild(r Record) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	k := key(r.Build.Jobname, r.Build.Buildnumber)
+	if !r.HasTestResult {
+		r.TestCases = m.records[k].TestCases
+	}
+	m.records[k] = r
+	return nil
+}
+
+func (m *MemorySink) Flush() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Flushed++
+	return nil
+}
+
+func (m *MemorySink) Close() error {
+	return nil
+}
+
+func (m *MemorySink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	return m.FindBuilds(jobName, bson.M{})
+}
+
+func (m *MemorySink) Build(jobName string, buildNumber int) (builddata.BuildData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r, ok := m.records[key(jobName, buildNumber)]
+	if !ok {
+		return builddata.BuildData{}, ErrNotFound
+	}
+	return r.Build, nil
+}
+
+// FindBuilds returns builds of a job that match 'filter', sorted by build numbers.
+// See Match for supported queries
+func (m *MemorySink) FindBuilds(jobName string, filter bson.M) ([]builddata.BuildData, error) {
+	builds := []builddata.BuildData{}
+	for _, r := range m.Records() {
+		if r.Build.Jobname != jobName {
+			continue
+		}
+		if len(filter) > 0 {
+			doc, err := bson.Marshal(&r.Build)
+			if err != nil {
+				return nil, err
+			}
+			d := bson.M{}
+			if err := bson.Unmarshal(doc, &d); err != nil {
+				return nil, err
+			}
+			if !Match(d, filter) {
+				continue
+			}
+		}
+		builds = append(builds, r.Build)
+	}
+	return builds, nil
+}
+
+func (m *MemorySink) JobNames() ([]string, error) {
+	jobNames := []string{}
+	for _, r := range m.Records() {
+		if n := len(jobNames); n == 0 || jobNames[n-1] != r.Build.Jobname {
+			jobNames = append(jobNames, r.Build.Jobname)
+		}
+	}
+	return jobNames, nil
+}
+
+func (m *MemorySink) MarkDeleted(jobName string, buildNumber int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	k := key(jobName, buildNumber)
+	r, ok := m.records[k]
+	if !ok {
+		return ErrNotFound
+	}
+	r.Build.Source_deleted = true
+	m.records[k] = r
+	return nil
+}
+
+func (m *MemorySink) UpsertFingerprint(f builddata.FingerprintData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.fingerprints[f.Md5] = f
+	return nil
+}
+
+func (m *MemorySink) FingerprintsOf(jobName string, buildNumber int) ([]builddata.FingerprintData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fingerprints := []builddata.FingerprintData{}
+	for _, f := range m.fingerprints {
+		if f.Original.Jobname == jobName && f.Original.Buildnumber == buildNumber {
+			fingerprints = append(fingerprints, f)
+		}
+	}
+	sort.Slice(fingerprints, func(i, j int) bool {
+		return fingerprints[i].Filename < fingerprints[j].Filename
+	})
+	return fingerprints, nil
+}
+
+func (m *MemorySink) UpsertJob(j builddata.JobData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.jobs[j.Jobname] = j
+	return nil
+}
+
+func (m *MemorySink) Job(jobName string) (builddata.JobData, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	j, ok := m.jobs[jobName]
+	if !ok {
+		return j, ErrNotFound
+	}
+	return j, nil
+}
+
+// Records returns stored records sorted by job names and build numbers
+func (m *MemorySink) Records() []Record {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	list := make([]Record, 0, len(m.records))
+	for _, r := range m.records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Build.Jobname != list[j].Build.Jobname {
+			return list[i].Build.Jobname < list[j].Build.Jobname
+		}
+		return list[i].Build.Buildnumber < list[j].Build.Buildnumber
+	})
+	return list
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func ExampleMemorySink() {
+	var s Store = NewMemorySink()
+	s.Open()
+	s.UpsertBuild(Record{
+		Build:         builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297},
+		TestCases:     []builddata.TestCaseData{{Name: "gvariant", Status: "PASSED"}},
+		HasTestResult: true,
+	})
+	s.UpsertBuild(Record{
+		Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297, Result: "SUCCESS"},
+	})
+	s.UpsertBuild(Record{
+		Build: builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 296, Result: "FAILURE"},
+	})
+	s.Flush()
+	s.MarkDeleted("starfish-drd4tv-official-h15", 296)
+	s.Close()
+	for _, r := range s.(*MemorySink).Records() {
+		fmt.Println(r.Build.Buildnumber, r.Build.Result, len(r.TestCases), r.Build.Source_deleted)
+	}
+	builds, _ := s.FindBuilds("starfish-drd4tv-official-h15", bson.M{"result": "SUCCESS"})
+	fmt.Println(len(builds), builds[0].Buildnumber)
+	fmt.Println(s.JobNames())
+	_, err := s.Build("starfish-drd4tv-official-h15", 298)
+	fmt.Println(err)
+	// Output:
+	// 296 FAILURE 0 true
+	// 297 SUCCESS 1 false
+	// 1 297
+	// [starfish-drd4tv-official-h15] <nil>
+	// not found
+}
@@ -0,0 +1,159 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/timeseries"
+	"github.com/golang/snappy"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InfluxSink writes builds as InfluxDB line protocol on a file, or sends them to a write endpoint
+// like 'http://localhost:8086/write?db=builds' on Flush.
+// A point is identified by its tags and a build start time, so writing a build again replaces it
+type InfluxSink struct {
+	Path   string
+	Url    string
+	Client *http.Client
+
+	mutex sync.Mutex
+	file  *os.File
+	lines []string
+}
+
+func (s *InfluxSink) Open() error {
+	if s.Client == nil {
+		s.Client = &http.Client{Timeout: time.Minute}
+	}
+	if s.Path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	return nil
+}
+
+// UpsertBuild writes a point of a build. Builds that haven't started yet have no time and are skipped
+func (s *InfluxSink) UpsertBuild(r Record) error {
+	if r.Build.Start == 0 {
+		return nil
+	}
+	line := timeseries.BuildPoint(r.Build).Line()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file != nil {
+		_, err := s.file.WriteString(line + "\n")
+		return err
+	}
+	s.lines = append(s.lines, line)
+	return nil
+}
+
+func (s *InfluxSink) Flush() error {
+	s.mutex.Lock()
+	lines := s.lines
+	s.lines = nil
+	s.mutex.Unlock()
+	if s.file != nil {
+		return s.file.Sync()
+	}
+	if len(lines) == 0 || s.Url == "" {
+		return nil
+	}
+	return post(s.Client, s.Url, []byte(strings.Join(lines, "\n")+"\n"), map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+	})
+}
+
+// StoredBuilds returns nothing, so every selected build is exported
+func (s *InfluxSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	return []builddata.BuildData{}, nil
+}
+
+func (s *InfluxSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// RemoteWriteSink sends builds to a Prometheus remote-write endpoint on Flush.
+// Prometheus itself rejects samples older than its head block, so backfilling old builds
+// needs a receiver that accepts them, e.g. Mimir or VictoriaMetrics
+type RemoteWriteSink struct {
+	Url    string
+	Client *http.Client
+
+	mutex  sync.Mutex
+	points []timeseries.Point
+}
+
+func (s *RemoteWriteSink) Open() error {
+	if s.Client == nil {
+		s.Client = &http.Client{Timeout: time.Minute}
+	}
+	return nil
+}
+
+func (s *RemoteWriteSink) UpsertBuild(r Record) error {
+	if r.Build.Start == 0 {
+		return nil
+	}
+	s.mutex.Lock()
+	s.points = append(s.points, timeseries.BuildPoint(r.Build))
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *RemoteWriteSink) Flush() error {
+	s.mutex.Lock()
+	points := s.points
+	s.points = nil
+	s.mutex.Unlock()
+	if len(points) == 0 {
+		return nil
+	}
+	return post(s.Client, s.Url, snappy.Encode(nil, timeseries.WriteRequest(points)), map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	})
+}
+
+// StoredBuilds returns nothing, so every selected build is exported
+func (s *RemoteWriteSink) StoredBuilds(jobName string) ([]builddata.BuildData, error) {
+	return []builddata.BuildData{}, nil
+}
+
+func (s *RemoteWriteSink) Close() error {
+	return nil
+}
+
+func post(client *http.Client, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		dat, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s failed: %d %s", url, resp.StatusCode, dat)
+	}
+	return nil
+}
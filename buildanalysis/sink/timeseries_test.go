@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"bytes"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/timeseries"
+	"github.com/golang/snappy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTimeSeriesSinks(t *testing.T) {
+	builds := []builddata.BuildData{
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 296, Start: 1551657600, Duration: 3600},
+		{Jobname: "starfish-drd4tv-official-h15", Buildnumber: 297, Duration: 0},
+	}
+	received := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") == "snappy" {
+			body, _ = snappy.Decode(nil, body)
+		}
+		received[r.URL.Path] = body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sinks := []Sink{
+		&InfluxSink{Url: server.URL + "/write?db=builds"},
+		&RemoteWriteSink{Url: server.URL + "/api/v1/write"},
+	}
+	for _, s := range sinks {
+		if err := s.Open(); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range builds {
+			s.UpsertBuild(Record{Build: b})
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+	}
+
+	// A build that hasn't started is skipped
+	point := timeseries.BuildPoint(builds[0])
+	if line := string(received["/write"]); line != point.Line()+"\n" {
+		t.Errorf("Line protocol = %q", line)
+	}
+	if !bytes.Equal(received["/api/v1/write"], timeseries.WriteRequest([]timeseries.Point{point})) {
+		t.Errorf("Remote write = %x", received["/api/v1/write"])
+	}
+}
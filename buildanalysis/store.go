@@ -0,0 +1,204 @@
+package main
+
+import (
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"github.com/all4dich/golang/buildanalysis/oebuildjobs"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AnalyzeOptions has settings for data that is extracted in addition to build.xml and log
+type AnalyzeOptions struct {
+	ChecksumArtifacts bool
+	ExpectedArtifacts []string
+	EnvAllow          []string
+	EnvDeny           []string
+	EnvSecrets        []string
+	// Instance is stored on records to tell builds of several Jenkins instances apart
+	Instance string
+}
+
+// NewBuildData analyzes a build directory and returns a record to store.
+// 'hasTestResult' is true if a build publishes JUnit results, even though it has no case.
+// It returns a *BuildError if build.xml can't be read or parsed
+func NewBuildData(buildJob string, opts AnalyzeOptions) (data builddata.BuildData, testCases []builddata.TestCaseData, hasTestResult bool, err error) {
+	v, b, err := AnalyzeBuild(buildJob)
+	if err != nil {
+		return data, testCases, false, err
+	}
+	data, testCases, hasTestResult = TransformBuild(buildJob, v, b, opts)
+	return data, testCases, hasTestResult, nil
+}
+
+// TransformBuild makes a record to store from values that have been parsed by ParseBuild,
+// and data of a build directory other than a build log and build.xml
+func TransformBuild(buildJob string, v oebuildjobs.BuildInfo, b map[string]string, opts AnalyzeOptions) (data builddata.BuildData, testCases []builddata.TestCaseData, hasTestResult bool) {
+	i_jobname := b["jobname"]
+	i_machine := jobMachine(i_jobname)
+	i_buildnumber, _ := strconv.Atoi(b["buildnumber"])
+	i_duration := v.Duration / 1000
+	i_start := v.Start / 1000
+	i_waiting_in_queue := v.WaitingTime / 1000
+	i_duration_in_queue := float64(v.WaitingTime) / 1000
+	i_timediff := 0
+	if v.GerritChangeInfo.ReceivedOn != 0 {
+		i_timediff = i_start - (v.GerritChangeInfo.ReceivedOn / 1000)
+	}
+	i_source_mtime, i_source_hash := BuildSource(buildJob)
+	// Layers are written like 'meta-qt5 = "HEAD:0123abcd"' on a build configuration
+	i_layers := []builddata.LayerData{}
+	for k, value := range b {
+		if !strings.HasPrefix(k, "meta") {
+			continue
+		}
+		ref := strings.SplitN(strings.Replace(value, "\"", "", -1), ":", 2)
+		layer := builddata.LayerData{Name: k, Branch: ref[0]}
+		if len(ref) == 2 {
+			layer.Revision = ref[1]
+		}
+		i_layers = append(i_layers, layer)
+	}
+	sort.Slice(i_layers, func(i, j int) bool { return i_layers[i].Name < i_layers[j].Name })
+	i_failures := []string{}
+	if b["errors"] != "" {
+		i_failures = strings.Split(strings.TrimSuffix(b["errors"], "\n"), "\n")
+	}
+	i_sstate_wanted, _ := strconv.Atoi(b["sstate_wanted"])
+	i_sstate_found, _ := strconv.Atoi(b["sstate_found"])
+	i_sstate_hit_rate := 0.0
+	if i_sstate_wanted > 0 {
+		i_sstate_hit_rate = float64(i_sstate_found) * 100 / float64(i_sstate_wanted)
+	}
+	i_parameters := bson.M{}
+	for _, eachParameter := range v.Parameters {
+		i_parameters[eachParameter.Name] = eachParameter.Value
+	}
+	i_parameters["BB_VERSION"] = strings.Replace(b["BB_VERSION"], "\"", "", -1)
+	i_parameters["BUILD_SYS"] = strings.Replace(b["BUILD_SYS"], "\"", "", -1)
+	i_parameters["NATIVELSBSTRING"] = strings.Replace(b["NATIVELSBSTRING"], "\"", "", -1)
+	i_parameters["TARGET_SYS"] = strings.Replace(b["TARGET_SYS"], "\"", "", -1)
+	i_parameters["DISTRO"] = strings.Replace(b["DISTRO"], "\"", "", -1)
+	i_parameters["DISTRO_VERSION"] = strings.Replace(b["DISTRO_VERSION"], "\"", "", -1)
+	i_parameters["TUNE_FEATURES"] = strings.Replace(b["TUNE_FEATURES"], "\"", "", -1)
+	i_parameters["TARGET_FPU"] = strings.Replace(b["TARGET_FPU"], "\"", "", -1)
+	i_parameters["WEBOS_DISTRO_MANUFACTURING_VERSION"] = strings.Replace(b["WEBOS_DISTRO_MANUFACTURING_VERSION"], "\"", "", -1)
+	i_parameters["WEBOS_ENCRYPTION_KEY_TYPE"] = strings.Replace(b["WEBOS_ENCRYPTION_KEY_TYPE"], "\"", "", -1)
+	i_parameters["WEBOS_DISTRO_RELEASE_CODENAME"] = strings.Replace(b["WEBOS_DISTRO_RELEASE_CODENAME"], "\"", "", -1)
+	i_parameters["WEBOS_DISTRO_BUILD_ID"] = strings.Replace(b["WEBOS_DISTRO_BUILD_ID"], "\"", "", -1)
+	i_parameters["WEBOS_DISTRO_TOPDIR_REVISION"] = strings.Replace(b["WEBOS_DISTRO_TOPDIR_REVISION"], "\"", "", -1)
+	i_parameters["WEBOS_DISTRO_TOPDIR_DESCRIBE"] = strings.Replace(b["WEBOS_DISTRO_TOPDIR_DESCRIBE"], "\"", "", -1)
+	i_parameters["caprica"] = b["caprica"]
+
+	testResult, hasTestResult := AnalyzeTestResult(buildJob)
+	testSummary := testResult.Summary()
+	testCases = []builddata.TestCaseData{}
+	for _, suite := range testResult.Suites {
+		for _, c := range suite.Cases {
+			testCases = append(testCases, builddata.TestCaseData{
+				Jobname:       i_jobname,
+				Buildnumber:   i_buildnumber,
+				Start:         i_start,
+				Suite:         suite.Name,
+				Classname:     c.ClassName,
+				Name:          c.TestName,
+				Duration:      c.Duration,
+				Status:        c.Status(),
+				Failed_since:  c.FailedSince,
+				Error_message: c.Message(),
+				Instance:      opts.Instance,
+			})
+		}
+	}
+
+	// Only a successful build is expected to archive every artifact
+	i_expected_artifacts := []string{}
+	if v.Result == "SUCCESS" {
+		i_expected_artifacts = opts.ExpectedArtifacts
+	}
+	i_artifacts, i_artifacts_size, i_missing_artifacts := AnalyzeArtifacts(buildJob, opts.ChecksumArtifacts, i_expected_artifacts)
+	i_manifests := AnalyzeManifests(buildJob, i_artifacts)
+	i_environment := AnalyzeEnvironment(buildJob, opts.EnvAllow, opts.EnvDeny, opts.EnvSecrets)
+
+	data = builddata.BuildData{
+		Jobname:           i_jobname,
+		Buildnumber:       i_buildnumber,
+		Result:            v.Result,
+		Host:              v.Host,
+		Duration:          i_duration,
+		Duration_in_queue: i_duration_in_queue,
+		Start:             i_start,
+		Waiting:           i_waiting_in_queue,
+		Workspace:         v.Workspace,
+		Description:       v.Description,
+		Timediff:          i_timediff,
+		Machine:           i_machine,
+		Parameters:        i_parameters,
+		Cause: bson.M{
+			"parent_project":     v.Causes.Parent_project,
+			"parent_user":        v.Causes.Parent_user,
+			"parent_buildnumber": v.Causes.Parent_buildnumber,
+			"parent_url":         v.Causes.Parent_url,
+			"userid":             v.Causes.Userid,
+			"retriggeredby":      v.Causes.Retriggeredby,
+		},
+		GerritChangeInfo: bson.M{
+			"project":      v.GerritChangeInfo.Project,
+			"branch":       v.GerritChangeInfo.Branch,
+			"changenumber": v.GerritChangeInfo.Changenumber,
+			"changeid":     v.GerritChangeInfo.Changeid,
+			"url":          v.GerritChangeInfo.Url,
+			"receivedon":   int(v.GerritChangeInfo.ReceivedOn / 1000),
+			"patchset": bson.M{
+				"number":    v.GerritChangeInfo.Patchset.Number,
+				"ref":       v.GerritChangeInfo.Patchset.Ref,
+				"parents":   v.GerritChangeInfo.Patchset.Parents,
+				"createdon": v.GerritChangeInfo.Patchset.CreatedOn,
+				"author": bson.M{
+					"name":  v.GerritChangeInfo.Patchset.Author.Name,
+					"email": v.GerritChangeInfo.Patchset.Author.Email,
+				},
+				"uploader": bson.M{
+					"name":  v.GerritChangeInfo.Patchset.Uploader.Name,
+					"email": v.GerritChangeInfo.Patchset.Uploader.Email,
+				},
+			},
+		},
+		GitChangeInfo: bson.M{
+			"branch":        v.GitChangeInfo.Branch,
+			"commithash":    v.GitChangeInfo.Commithash,
+			"buildnumber":   v.GitChangeInfo.Buildnumber,
+			"repositoryurl": v.GitChangeInfo.Repositoryurl,
+		},
+		Time_build_sh:           GetFloat(b["time_build_sh"], i_buildnumber),
+		Time_bitbake:            GetFloat(b["time_bitbake"], i_buildnumber),
+		Time_rm_BUILD:           GetFloat(b["time_rm_BUILD"], i_buildnumber),
+		Time_rm_BUILD_ARTIFACTS: GetFloat(b["time_rm_BUILD_ARTIFACTS"], i_buildnumber),
+		Time_rm_downloads:       GetFloat(b["time_rm_downloads"], i_buildnumber),
+		Time_rm_sstate:          GetFloat(b["time_rm_sstate"], i_buildnumber),
+		Time_rsync_artifacts:    GetFloat(b["time_rsync_artifacts"], i_buildnumber),
+		Sstate_wanted:           i_sstate_wanted,
+		Sstate_found:            i_sstate_found,
+		Sstate_hit_rate:         i_sstate_hit_rate,
+		Instance:                opts.Instance,
+		Tests_total:             testSummary.Total,
+		Tests_passed:            testSummary.Passed,
+		Tests_failed:            testSummary.Failed,
+		Tests_skipped:           testSummary.Skipped,
+		Tests_duration:          testSummary.Duration,
+		Artifacts:               i_artifacts,
+		Artifacts_count:         len(i_artifacts),
+		Artifacts_size:          i_artifacts_size,
+		Missing_artifacts:       i_missing_artifacts,
+		Manifests:               i_manifests,
+		Environment:             i_environment,
+		Provisional:             v.Result == "",
+		Source_mtime:            i_source_mtime,
+		Source_hash:             i_source_hash,
+		Parser_version:          ParserVersion,
+		Layers:                  i_layers,
+		Failures:                i_failures,
+	}
+	return data, testCases, hasTestResult
+}
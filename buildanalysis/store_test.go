@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+)
+
+const samplesJobsDir = "../samples/jenkins_home/jobs"
+
+func ExampleNewBuildData() {
+	for _, buildDir := range []string{
+		samplesJobsDir + "/starfish-drd4tv-official-h15/builds/2",
+		// A job name that isn't like '<distro>-<target>-<type>-<machine>' has no machine
+		samplesJobsDir + "/tools/builds/1",
+	} {
+		data, _, _, err := NewBuildData(buildDir, AnalyzeOptions{})
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%s %d %s machine=%q sstate=%.0f%% failures=%v\n",
+			data.Jobname, data.Buildnumber, data.Result, data.Machine, data.Sstate_hit_rate, data.Failures)
+	}
+	_, _, _, err := NewBuildData(samplesJobsDir+"/tools/builds/9", AnalyzeOptions{})
+	fmt.Println(err != nil)
+	// Output:
+	// starfish-drd4tv-official-h15 2 FAILURE machine="h15" sstate=80% failures=[ERROR: Task do_compile failed]
+	// tools 1 SUCCESS machine="" sstate=80% failures=[]
+	// true
+}
@@ -0,0 +1,172 @@
+package timeseries
+
+import (
+	"encoding/binary"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is a set of values of a build at its start time
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+// Names of fields of a job name like 'starfish-drd4tv-official-h15'
+var JobNameTags = []string{"distro", "flavor", "type", "machine"}
+
+// BuildTags returns tags of a build from its job name and host
+func BuildTags(b builddata.BuildData) map[string]string {
+	tags := map[string]string{"job": b.Jobname}
+	arr := strings.Split(b.Jobname, "-")
+	if len(arr) == len(JobNameTags) {
+		for i, name := range JobNameTags {
+			tags[name] = arr[i]
+		}
+	}
+	if b.Machine != "" {
+		tags["machine"] = b.Machine
+	}
+	if b.Host != "" {
+		tags["host"] = b.Host
+	}
+	return tags
+}
+
+// BuildPoint returns a point of a build with durations in seconds, a sstate hit rate,
+// and 'success' that is 1 for a successful build and 0 for others.
+// A result is a field rather than a tag, so a build that is written again with another result
+// replaces its point instead of adding a series.
+// Steps that aren't found on a build log, an unknown sstate hit rate and a result of a running build are left out
+func BuildPoint(b builddata.BuildData) Point {
+	fields := map[string]float64{
+		"duration":   float64(b.Duration),
+		"queue_wait": b.Duration_in_queue,
+	}
+	if b.Time_bitbake != 0 {
+		fields["time_bitbake"] = b.Time_bitbake
+	}
+	if b.Sstate_wanted > 0 {
+		fields["sstate_hit_rate"] = b.Sstate_hit_rate
+	}
+	if b.Result == "SUCCESS" {
+		fields["success"] = 1
+	} else if b.Result != "" {
+		fields["success"] = 0
+	}
+	return Point{
+		Measurement: "build",
+		Tags:        BuildTags(b),
+		Fields:      fields,
+		Time:        time.Unix(int64(b.Start), 0),
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// Line returns a point in InfluxDB line protocol with a timestamp in nanoseconds, e.g.
+//
+//	build,host=builder1,job=starfish-drd4tv-official-h15 duration=3600,queue_wait=1.5 1551657600000000000
+func (p Point) Line() string {
+	var sb strings.Builder
+	sb.WriteString(measurementEscaper.Replace(p.Measurement))
+	for _, k := range sortedKeys(p.Tags) {
+		// InfluxDB doesn't accept an empty tag value
+		if p.Tags[k] == "" {
+			continue
+		}
+		sb.WriteString("," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(p.Tags[k]))
+	}
+	names := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for i, k := range names {
+		if i == 0 {
+			sb.WriteString(" ")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(tagEscaper.Replace(k) + "=" + strconv.FormatFloat(p.Fields[k], 'f', -1, 64))
+	}
+	sb.WriteString(" " + strconv.FormatInt(p.Time.UnixNano(), 10))
+	return sb.String()
+}
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// WriteRequest encodes points as a Prometheus remote-write request before snappy compression.
+// Each field is a series named like 'build_duration' with tags as labels
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func WriteRequest(points []Point) []byte {
+	var req []byte
+	for _, p := range points {
+		names := make([]string, 0, len(p.Fields))
+		for k := range p.Fields {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, field := range names {
+			labels := map[string]string{"__name__": p.Measurement + "_" + field}
+			for k, v := range p.Tags {
+				if v != "" {
+					labels[k] = v
+				}
+			}
+			var series []byte
+			// Labels have to be sorted by names
+			for _, k := range sortedKeys(labels) {
+				var label []byte
+				label = appendBytes(label, 1, []byte(k))
+				label = appendBytes(label, 2, []byte(labels[k]))
+				series = appendBytes(series, 1, label)
+			}
+			var sample []byte
+			sample = appendTag(sample, 1, wireFixed64)
+			sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(p.Fields[field]))
+			sample = appendTag(sample, 2, wireVarint)
+			sample = binary.AppendUvarint(sample, uint64(p.Time.UnixNano()/int64(time.Millisecond)))
+			series = appendBytes(series, 2, sample)
+			req = appendBytes(req, 1, series)
+		}
+	}
+	return req
+}
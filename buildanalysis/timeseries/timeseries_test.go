@@ -0,0 +1,35 @@
+package timeseries
+
+import (
+	"fmt"
+	"github.com/all4dich/golang/buildanalysis/builddata"
+)
+
+func ExamplePoint_Line() {
+	p := BuildPoint(builddata.BuildData{
+		Jobname:           "starfish-drd4tv-official-h15",
+		Result:            "SUCCESS",
+		Host:              "build farm 1",
+		Start:             1551657600,
+		Duration:          3600,
+		Duration_in_queue: 1.5,
+		Time_bitbake:      3000.25,
+		Sstate_wanted:     1234,
+		Sstate_found:      1000,
+		Sstate_hit_rate:   81.04,
+	})
+	fmt.Println(p.Line())
+	// Output:
+	// build,distro=starfish,flavor=drd4tv,host=build\ farm\ 1,job=starfish-drd4tv-official-h15,machine=h15,type=official duration=3600,queue_wait=1.5,sstate_hit_rate=81.04,success=1,time_bitbake=3000.25 1551657600000000000
+}
+
+func ExampleBuildPoint() {
+	// A build that was written while running, and again once it failed, has the same tags
+	running := BuildPoint(builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Start: 1551657600, Duration: 60})
+	failed := BuildPoint(builddata.BuildData{Jobname: "starfish-drd4tv-official-h15", Start: 1551657600, Duration: 3600, Result: "FAILURE"})
+	fmt.Println(running.Line())
+	fmt.Println(failed.Line())
+	// Output:
+	// build,distro=starfish,flavor=drd4tv,job=starfish-drd4tv-official-h15,machine=h15,type=official duration=60,queue_wait=0 1551657600000000000
+	// build,distro=starfish,flavor=drd4tv,job=starfish-drd4tv-official-h15,machine=h15,type=official duration=3600,queue_wait=0,success=0 1551657600000000000
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var validBuildNumber = regexp.MustCompile(`^\d+$`)
+
+// Watcher finds new builds on a job's builds directory and sends them to 'Builds'
+// once they are finished. New directories are detected with inotify, and
+// the directory is polled every 'Interval' instead if 'Poll' is set or inotify
+// doesn't work, e.g. on NFS mounts.
+// Builds whose build.xml has been changed since they were sent or known are checked again
+// on every scan, so provisional and edited builds are analyzed again
+type Watcher struct {
+	BuildsDir string
+	Interval  time.Duration
+	Poll      bool
+	// Rescan is how often a builds directory is scanned on inotify mode
+	// to find changed builds. 10 times 'Interval' if it's 0
+	Rescan time.Duration
+	// PendingTimeout is how long a build is waited for until it's finished. A build that doesn't
+	// get a result by then is checked again once its build.xml is changed. 24 hours if it's 0
+	PendingTimeout time.Duration
+	// Refresh is called once before builds are checked with Known on a scan, e.g. to read stored builds
+	Refresh func()
+	// Known checks if a build has been stored and doesn't need to be analyzed again
+	Known  func(buildDir string) bool
+	Builds chan<- string
+
+	// pending: when builds that are still running were found
+	// seen: modification times of build.xml of builds that have been sent or known
+	pending map[string]time.Time
+	seen    map[string]time.Time
+}
+
+// buildXmlTime returns a modification time of build.xml, or a zero time if it doesn't exist
+func buildXmlTime(buildDir string) time.Time {
+	fi, err := os.Stat(buildDir + "/build.xml")
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func (w *Watcher) add(buildDir string) {
+	if w.Known != nil && w.Known(buildDir) {
+		w.seen[buildDir] = buildXmlTime(buildDir)
+		return
+	}
+	if _, ok := w.seen[buildDir]; ok {
+		log.Println("INFO: Found a changed build ", buildDir)
+		delete(w.seen, buildDir)
+	} else {
+		log.Println("INFO: Found a new build ", buildDir)
+	}
+	w.pending[buildDir] = time.Now()
+}
+
+// scan checks builds that are new or whose build.xml has been changed
+func (w *Watcher) scan() {
+	builds, err := ioutil.ReadDir(w.BuildsDir)
+	if err != nil {
+		log.Println("ERROR: Cannot read " + w.BuildsDir + ": " + err.Error())
+		return
+	}
+	changed := []string{}
+	for _, build := range builds {
+		if !build.IsDir() || !validBuildNumber.MatchString(build.Name()) {
+			continue
+		}
+		buildDir := w.BuildsDir + "/" + build.Name()
+		if _, ok := w.pending[buildDir]; ok {
+			continue
+		}
+		if t, ok := w.seen[buildDir]; ok && t.Equal(buildXmlTime(buildDir)) {
+			continue
+		}
+		changed = append(changed, buildDir)
+	}
+	if len(changed) > 0 && w.Refresh != nil {
+		w.Refresh()
+	}
+	for _, buildDir := range changed {
+		w.add(buildDir)
+	}
+}
+
+// flush sends pending builds that have been finished until 'ctx' is cancelled,
+// and gives up builds that have been pending longer than 'PendingTimeout'
+func (w *Watcher) flush(ctx context.Context) {
+	for buildDir, found := range w.pending {
+		if time.Since(found) > w.PendingTimeout {
+			log.Println("WARNING: " + buildDir + " hasn't been finished, check it again once build.xml is changed")
+			delete(w.pending, buildDir)
+			w.seen[buildDir] = buildXmlTime(buildDir)
+			continue
+		}
+		if _, err := os.Stat(buildDir + "/log"); err != nil {
+			continue
+		}
+		if !IsBuildFinished(buildDir) {
+			continue
+		}
+		select {
+		case w.Builds <- buildDir:
+		case <-ctx.Done():
+			return
+		}
+		delete(w.pending, buildDir)
+		w.seen[buildDir] = buildXmlTime(buildDir)
+	}
+}
+
+// Run watches a builds directory until 'ctx' is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	if w.Rescan == 0 {
+		w.Rescan = 10 * w.Interval
+	}
+	if w.PendingTimeout == 0 {
+		w.PendingTimeout = 24 * time.Hour
+	}
+	w.pending = make(map[string]time.Time)
+	w.seen = make(map[string]time.Time)
+	w.scan()
+	scanned := time.Now()
+
+	var events chan fsnotify.Event
+	var errors chan error
+	if !w.Poll {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = watcher.Add(w.BuildsDir)
+		}
+		if err != nil {
+			log.Println("WARNING: Cannot watch " + w.BuildsDir + ", poll it instead: " + err.Error())
+			w.Poll = true
+		} else {
+			defer watcher.Close()
+			events = watcher.Events
+			errors = watcher.Errors
+		}
+	}
+	log.Println("INFO: Watching ", w.BuildsDir)
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				log.Println("WARNING: Stopped watching " + w.BuildsDir + ", poll it instead")
+				events = nil
+				w.Poll = true
+				continue
+			}
+			if e.Op&fsnotify.Create == 0 || !validBuildNumber.MatchString(filepath.Base(e.Name)) {
+				continue
+			}
+			_, isPending := w.pending[e.Name]
+			_, isSeen := w.seen[e.Name]
+			if fi, err := os.Stat(e.Name); err == nil && fi.IsDir() && !isPending && !isSeen {
+				w.add(e.Name)
+			}
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			log.Println("ERROR: ", err)
+		case now := <-ticker.C:
+			if w.Poll || now.Sub(scanned) >= w.Rescan {
+				w.scan()
+				scanned = now
+			}
+			w.flush(ctx)
+		}
+	}
+}
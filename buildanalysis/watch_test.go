@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// writeBuild writes a build.xml and a log of a build. A running build doesn't have a result
+func writeBuild(buildsDir string, name string, result string) string {
+	buildDir := filepath.Join(buildsDir, name)
+	os.MkdirAll(buildDir, 0755)
+	xml := "<?xml version='1.1' encoding='UTF-8'?>\n<build><number>" + name + "</number>"
+	if result != "" {
+		xml += "<result>" + result + "</result>"
+	}
+	ioutil.WriteFile(buildDir+"/build.xml", []byte(xml+"</build>\n"), 0644)
+	ioutil.WriteFile(buildDir+"/log", []byte("Started by user builder\n"), 0644)
+	return buildDir
+}
+
+func ExampleWatcher() {
+	buildsDir, err := ioutil.TempDir("", "builds")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(buildsDir)
+	writeBuild(buildsDir, "1", "SUCCESS")
+	writeBuild(buildsDir, "2", "FAILURE")
+	writeBuild(buildsDir, "3", "")
+	writeBuild(buildsDir, "4", "")
+	os.MkdirAll(buildsDir+"/lastStableBuild", 0755)
+
+	builds := make(chan string, 10)
+	refreshed := 0
+	w := &Watcher{
+		BuildsDir:      buildsDir,
+		PendingTimeout: time.Hour,
+		Refresh:        func() { refreshed++ },
+		Known:          func(buildDir string) bool { return filepath.Base(buildDir) == "1" },
+		Builds:         builds,
+		pending:        make(map[string]time.Time),
+		seen:           make(map[string]time.Time),
+	}
+	ctx := context.Background()
+	received := func() []string {
+		names := []string{}
+		for len(builds) > 0 {
+			names = append(names, filepath.Base(<-builds))
+		}
+		sort.Strings(names)
+		return names
+	}
+	pending := func() []string {
+		names := []string{}
+		for buildDir := range w.pending {
+			names = append(names, filepath.Base(buildDir))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// A known build isn't sent, and running builds are pending until they are finished
+	w.scan()
+	w.flush(ctx)
+	fmt.Println(received(), pending(), refreshed)
+
+	// Builds that haven't changed aren't checked again, and stored builds aren't read
+	w.scan()
+	fmt.Println(received(), pending(), refreshed)
+
+	// A build that has been finished is sent on a next flush
+	writeBuild(buildsDir, "3", "ABORTED")
+	w.flush(ctx)
+	fmt.Println(received(), pending())
+
+	// A build that has been pending too long is given up until its build.xml is changed
+	w.pending[buildsDir+"/4"] = time.Now().Add(-2 * time.Hour)
+	w.flush(ctx)
+	w.scan()
+	fmt.Println(received(), pending(), refreshed)
+
+	// Builds whose build.xml has been changed are found again, e.g. an edited description
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(buildsDir+"/2/build.xml", later, later)
+	writeBuild(buildsDir, "4", "SUCCESS")
+	os.Chtimes(buildsDir+"/4/build.xml", later, later)
+	w.scan()
+	w.flush(ctx)
+	fmt.Println(received(), pending(), refreshed)
+	// Output:
+	// [2] [3 4] 1
+	// [] [3 4] 1
+	// [3] [4]
+	// [] [] 1
+	// [2 4] [] 2
+}